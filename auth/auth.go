@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 )
 
 // NakamaAuthResponse represents the structure returned by Nakama on authentication
@@ -95,3 +96,29 @@ func sendAuthRequestWithBasicAuth(url string, payload map[string]interface{}, se
 
 	return &authResp, nil
 }
+
+// UserIDFromToken extracts the user ID from a Nakama session token.
+// The signature is not verified; the server does that on every request.
+func UserIDFromToken(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("session token is not a JWT")
+	}
+
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("failed to decode session token: %w", err)
+	}
+
+	var claims struct {
+		UserID string `json:"uid"`
+	}
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		return "", fmt.Errorf("failed to parse session token: %w", err)
+	}
+	if claims.UserID == "" {
+		return "", fmt.Errorf("session token has no user ID")
+	}
+
+	return claims.UserID, nil
+}
@@ -0,0 +1,205 @@
+// Package bundle packs and unpacks the tar archives used for job inputs and artifacts
+package bundle
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// MaxFiles caps how many entries an archive may hold
+const MaxFiles = 10000
+
+// File describes one regular file in an archive
+type File struct {
+	Path   string `json:"path"`   // Slash-separated path relative to the archive root
+	Size   int64  `json:"size"`   // Size in bytes
+	SHA256 string `json:"sha256"` // Hex checksum of the content
+}
+
+// CleanPath checks that p stays inside the directory it is unpacked in and
+// returns it in clean slash-separated form
+func CleanPath(p string) (string, error) {
+	clean := path.Clean(strings.TrimPrefix(p, "./"))
+	if clean == "." || clean == "" || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("invalid path %q", p)
+	}
+	return clean, nil
+}
+
+// Checksum returns the hex SHA-256 of data
+func Checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Extract unpacks a tar archive, optionally gzip compressed, into dir.
+// Only regular files and directories are accepted, every path must stay
+// inside dir, and extraction stops once the files exceed maxBytes.
+func Extract(r io.Reader, dir string, maxBytes int64) ([]File, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+
+	var (
+		files []File
+		total int64
+	)
+	tr := tar.NewReader(r)
+	for n := 0; ; n++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n >= MaxFiles {
+			return nil, fmt.Errorf("archive has more than %d entries", MaxFiles)
+		}
+
+		name, err := CleanPath(hdr.Name)
+		if err != nil {
+			return nil, err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return nil, err
+			}
+		case tar.TypeReg:
+			total += hdr.Size
+			if total > maxBytes {
+				return nil, fmt.Errorf("archive exceeds %d bytes", maxBytes)
+			}
+			file, err := writeFile(target, tr, hdr.Size)
+			if err != nil {
+				return nil, err
+			}
+			file.Path = name
+			files = append(files, file)
+		default:
+			return nil, fmt.Errorf("unsupported entry %q: only files and directories are allowed", hdr.Name)
+		}
+	}
+}
+
+// writeFile writes exactly size bytes from r to a new file and returns its description
+func writeFile(target string, r io.Reader, size int64) (File, error) {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return File{}, err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return File{}, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.CopyN(io.MultiWriter(f, h), r, size); err != nil {
+		return File{}, err
+	}
+	return File{Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// Create writes a gzip compressed tar of the regular files under dir to w,
+// in path order. Files that would take the total past maxBytes are left out
+// and returned as skipped. Symlinks and other special files are ignored.
+func Create(w io.Writer, dir string, maxBytes int64) (files []File, skipped []string, err error) {
+	var paths []string
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			paths = append(paths, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(paths)
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	var total int64
+	for _, p := range paths {
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return nil, nil, err
+		}
+		name := filepath.ToSlash(rel)
+
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(files) >= MaxFiles || total+info.Size() > maxBytes {
+			skipped = append(skipped, name)
+			continue
+		}
+
+		file, err := addFile(tw, p, name, info.Size())
+		if err != nil {
+			return nil, nil, err
+		}
+		total += file.Size
+		files = append(files, file)
+	}
+	if err := tw.Close(); err != nil {
+		return nil, nil, err
+	}
+	return files, skipped, gz.Close()
+}
+
+// addFile appends one file to the archive and returns its description
+func addFile(tw *tar.Writer, p, name string, size int64) (File, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return File{}, err
+	}
+	defer f.Close()
+
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		return File{}, err
+	}
+	h := sha256.New()
+	// The file may still be growing, so copy exactly the size in the header
+	if _, err := io.CopyN(io.MultiWriter(tw, h), f, size); err != nil {
+		return File{}, errors.New("file " + name + " changed while it was archived")
+	}
+	return File{Path: name, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// ExtractBytes is Extract for an archive held in memory
+func ExtractBytes(data []byte, dir string, maxBytes int64) ([]File, error) {
+	return Extract(bytes.NewReader(data), dir, maxBytes)
+}
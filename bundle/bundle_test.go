@@ -0,0 +1,166 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// entry is one member of a test archive
+type entry struct {
+	name     string
+	typeflag byte
+	body     string
+	linkname string
+}
+
+// makeTar returns an uncompressed tar holding the entries in order
+func makeTar(t *testing.T, entries ...entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.linkname, Mode: 0644}
+		if e.typeflag == tar.TypeReg {
+			hdr.Size = int64(len(e.body))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string // Empty when the path must be refused
+	}{
+		{"data.csv", "data.csv"},
+		{"./data.csv", "data.csv"},
+		{"dir/sub/../data.csv", "dir/data.csv"},
+		{"dir//data.csv", "dir/data.csv"},
+		{"", ""},
+		{".", ""},
+		{"./", ""},
+		{"..", ""},
+		{"../data.csv", ""},
+		{"dir/../../data.csv", ""},
+		{"/etc/passwd", ""},
+		{"/", ""},
+	}
+	for _, tt := range tests {
+		got, err := CleanPath(tt.path)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("CleanPath(%q) = %q, want an error", tt.path, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("CleanPath(%q) = %q, %v, want %q", tt.path, got, err, tt.want)
+		}
+	}
+}
+
+func TestExtractRefusesEntriesOutsideDir(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+	}{
+		{"parent path", []entry{{name: "../escape.txt", typeflag: tar.TypeReg, body: "x"}}},
+		{"nested parent path", []entry{{name: "dir/../../escape.txt", typeflag: tar.TypeReg, body: "x"}}},
+		{"absolute path", []entry{{name: "/tmp/escape.txt", typeflag: tar.TypeReg, body: "x"}}},
+		{"symlink", []entry{{name: "link", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"}}},
+		{"hard link", []entry{{name: "link", typeflag: tar.TypeLink, linkname: "data.csv"}}},
+		{"symlink then file through it", []entry{
+			{name: "link", typeflag: tar.TypeSymlink, linkname: ".."},
+			{name: "link/escape.txt", typeflag: tar.TypeReg, body: "x"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent := t.TempDir()
+			dir := filepath.Join(parent, "inputs")
+			if _, err := ExtractBytes(makeTar(t, tt.entries...), dir, 1<<20); err == nil {
+				t.Fatal("want the archive refused")
+			}
+			if _, err := os.Lstat(filepath.Join(parent, "escape.txt")); !os.IsNotExist(err) {
+				t.Error("want nothing written outside the directory")
+			}
+			if _, err := os.Lstat(filepath.Join(dir, "link")); !os.IsNotExist(err) {
+				t.Error("want no link created")
+			}
+		})
+	}
+}
+
+func TestExtractSizeLimit(t *testing.T) {
+	data := makeTar(t,
+		entry{name: "a.txt", typeflag: tar.TypeReg, body: strings.Repeat("a", 60)},
+		entry{name: "b.txt", typeflag: tar.TypeReg, body: strings.Repeat("b", 40)},
+	)
+
+	if _, err := ExtractBytes(data, t.TempDir(), 100); err != nil {
+		t.Errorf("want an archive of exactly the limit accepted, got %v", err)
+	}
+
+	dir := t.TempDir()
+	_, err := ExtractBytes(data, dir, 99)
+	if err == nil || !strings.Contains(err.Error(), "exceeds 99 bytes") {
+		t.Fatalf("want the archive over the limit refused, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.txt")); !os.IsNotExist(err) {
+		t.Error("want the file past the limit not written")
+	}
+}
+
+func TestCreateThenExtract(t *testing.T) {
+	src := t.TempDir()
+	os.MkdirAll(filepath.Join(src, "sub"), 0755)
+	os.WriteFile(filepath.Join(src, "a.txt"), []byte("hello"), 0644)
+	os.WriteFile(filepath.Join(src, "sub", "b.txt"), []byte("world"), 0644)
+	os.WriteFile(filepath.Join(src, "big.bin"), bytes.Repeat([]byte{1}, 100), 0644)
+	if err := os.Symlink("/etc/passwd", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	files, skipped, err := Create(&buf, src, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skipped) != 1 || skipped[0] != "big.bin" {
+		t.Errorf("want big.bin skipped, got %v", skipped)
+	}
+	if len(files) != 2 || files[0].Path != "a.txt" || files[1].Path != "sub/b.txt" {
+		t.Fatalf("want the regular files in path order without the symlink, got %+v", files)
+	}
+
+	// The gzip compressed archive is detected and unpacked
+	dst := t.TempDir()
+	extracted, err := ExtractBytes(buf.Bytes(), dst, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range extracted {
+		if f != files[i] {
+			t.Errorf("extracted %+v, want %+v", f, files[i])
+		}
+	}
+	if got, _ := os.ReadFile(filepath.Join(dst, "sub", "b.txt")); string(got) != "world" {
+		t.Errorf("want sub/b.txt unpacked, got %q", got)
+	}
+	if files[0].SHA256 != Checksum([]byte("hello")) {
+		t.Errorf("want the checksum of the content, got %s", files[0].SHA256)
+	}
+}
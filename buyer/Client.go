@@ -1,95 +1,107 @@
 package buyer
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"flag"
-	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/bdr-pro/lumaris/auth"
 	"github.com/bdr-pro/lumaris/modules"
+	"github.com/bdr-pro/lumaris/rpcclient"
 	"github.com/google/uuid"
 )
 
 // ClientMain is the REST-based client entry point
 func ClientMain() {
 	// Parse flags
-	nakamaServer := flag.String("server", "127.0.0.1:7350", "Nakama server address")
-	sessionToken := flag.String("token", "", "Nakama session token")
-	flag.Parse()
+	clientFlags := flag.NewFlagSet("buyer", flag.ExitOnError)
+	nakamaServer := clientFlags.String("server", "127.0.0.1:7350", "Nakama server address")
+	sessionToken := clientFlags.String("token", "", "Nakama session token")
+	wait := clientFlags.Duration("wait", 5*time.Minute, "How long to wait for the job result")
+	image := clientFlags.String("image", "python:3.10", "Docker image to use")
+	command := clientFlags.String("command", "python -c 'print(\"Hello from compute marketplace!\")'", "Command to run")
+	var inputFiles inputFlag
+	clientFlags.Var(&inputFiles, "input", "File to place in /in, as local-path[=path-in-/in]; may be repeated")
+	inputBundle := clientFlags.String("input-bundle", "", "Tar archive (optionally gzip compressed) to unpack into /in")
+	artifactDir := clientFlags.String("artifacts", "", "Directory to unpack the files the job writes to /out into")
+	maxPrice := clientFlags.Int64("max-price", 0, "Most credits to pay for the job; only sellers within it are offered the job (0: no ceiling)")
+	auctionRule := clientFlags.String("auction", "", "Place the job by auction, awarding it by rule: cheapest, fastest, reputation or manual (requires -max-price)")
+	bidWindow := clientFlags.Int64("bid-window", 0, "Seconds sellers may bid when -auction is set (default: 30)")
+	verify := clientFlags.Int("verify", 0, "Run the job on this many independent sellers and deliver the result most of them agree on (0: a single seller)")
+	minReputation := clientFlags.Float64("min-reputation", 0, "Lowest seller reputation score, between 0 and 1, to offer the job to (0: any seller)")
+	clientFlags.Parse(os.Args[2:])
 
 	if *sessionToken == "" {
 		log.Fatal("You must provide a session token using -token")
 	}
 
-	userID := "buyer-user-id-placeholder" // Replace if needed
+	// The server fills in the buyer from the session, but sending it keeps the request self-describing
+	userID, err := auth.UserIDFromToken(*sessionToken)
+	if err != nil {
+		log.Fatalf("Invalid session token: %v", err)
+	}
+
+	inputs, err := readInputs(inputFiles, *inputBundle)
+	if err != nil {
+		log.Fatalf("Invalid inputs: %v", err)
+	}
 
 	// Create job
 	jobID := uuid.New().String()
 	job := modules.JobRequest{
-		Image:   "python:3.10",
-		Command: "python -c 'print(\"Hello from compute marketplace!\")'",
-		BuyerID: userID,
-		JobID:   jobID,
-	}
+		Image:    *image,
+		Command:  *command,
+		BuyerID:  userID,
+		JobID:    jobID,
+		Inputs:   inputs,
+		MaxPrice: *maxPrice,
 
-	log.Printf("Sending job with ID: %s\n", jobID)
-	err := sendJobRequest(*nakamaServer, *sessionToken, job)
-	if err != nil {
-		log.Fatalf("Failed to send job: %v", err)
+		MinReputation: *minReputation,
+	}
+	if *verify > 0 {
+		job.Verification = &modules.VerificationOptions{Replicas: *verify}
+	}
+	if *auctionRule != "" {
+		job.Auction = &modules.AuctionOptions{Rule: *auctionRule, WindowSeconds: *bidWindow}
 	}
-	log.Println("Job sent successfully. Waiting for result...")
 
-	// Simulate wait for result (e.g., polling, long-polling, or notification system)
-	waitForResult(jobID)
+	// Stop waiting on CTRL+C or once the wait timeout passes
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	ctx, cancelWait := context.WithTimeout(ctx, *wait)
+	defer cancelWait()
 
-	// Handle graceful shutdown
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	<-sigCh
-	log.Println("Shutting down.")
-}
+	// Listen before sending, so a quick result is not missed
+	waiter := listenForResult(ctx, *nakamaServer, *sessionToken, jobID)
 
-// sendJobRequest sends the job as an RPC request using Nakama's REST API
-func sendJobRequest(server, token string, job modules.JobRequest) error {
-	jobData, err := json.Marshal(job)
+	log.Printf("Sending job with ID: %s\n", jobID)
+	err = sendJobRequest(*nakamaServer, *sessionToken, job)
 	if err != nil {
-		return fmt.Errorf("failed to marshal job: %w", err)
+		log.Fatalf("Failed to send job: %v", err)
 	}
+	log.Println("Job sent successfully. Waiting for result...")
 
-	url := fmt.Sprintf("http://%s/v2/rpc/send_job", server)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jobData))
+	result, err := waiter.wait(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		log.Fatalf("No result for job %s: %v", jobID, err)
 	}
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send RPC: %w", err)
+	printResult(result)
+	if *artifactDir != "" && result.Artifacts != nil {
+		if err := downloadArtifacts(rpcclient.New(*nakamaServer, *sessionToken, nil), result, *artifactDir); err != nil {
+			log.Fatalf("Failed to download artifacts of job %s: %v", jobID, err)
+		}
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("RPC failed [%d]: %s", resp.StatusCode, string(body))
+	if result.State != modules.JobSucceeded {
+		os.Exit(1)
 	}
-
-	return nil
 }
 
-// waitForResult is a placeholder for waiting on job results.
-// You can later connect this to a websocket or polling endpoint.
-func waitForResult(jobID string) {
-	log.Printf("Pretending to wait for job result... [Job ID: %s]", jobID)
-	time.Sleep(3 * time.Second) // Simulate waiting
-	log.Printf("Job %s finished. (Result fetching not implemented)", jobID)
+// sendJobRequest sends the job as an RPC request using Nakama's REST API
+func sendJobRequest(server, token string, job modules.JobRequest) error {
+	_, err := rpcclient.New(server, token, nil).Call("send_job", job)
+	return err
 }
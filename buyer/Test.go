@@ -33,7 +33,7 @@ func Test() {
 	job := modules.JobRequest{
 		Image:   *image,
 		Command: *command,
-		BuyerID: "buyer-uuid-placeholder", // Replace with actual user ID if needed
+		BuyerID: "", // Filled in by the server from the session
 		JobID:   jobID,
 	}
 
@@ -0,0 +1,147 @@
+package buyer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bdr-pro/lumaris/bundle"
+	"github.com/bdr-pro/lumaris/modules"
+	"github.com/bdr-pro/lumaris/rpcclient"
+)
+
+// ArtifactsMain downloads the files a finished job wrote to /out and unpacks them locally
+func ArtifactsMain() {
+	artifactFlags := flag.NewFlagSet("artifacts", flag.ExitOnError)
+	nakamaServer := artifactFlags.String("server", "127.0.0.1:7350", "Nakama server address")
+	sessionToken := artifactFlags.String("token", "", "Nakama session token")
+	dir := artifactFlags.String("dir", ".", "Directory to unpack the artifacts into")
+	artifactFlags.Usage = func() {
+		fmt.Println("Usage: lumaris artifacts [options] <job-id>")
+		artifactFlags.PrintDefaults()
+	}
+	artifactFlags.Parse(os.Args[2:])
+
+	if *sessionToken == "" {
+		log.Fatal("You must provide a session token using -token")
+	}
+	if artifactFlags.NArg() != 1 {
+		artifactFlags.Usage()
+		os.Exit(1)
+	}
+	jobID := artifactFlags.Arg(0)
+
+	client := rpcclient.New(*nakamaServer, *sessionToken, nil)
+	job, err := fetchJob(client, jobID)
+	if err != nil {
+		log.Fatalf("Failed to read job %s: %v", jobID, err)
+	}
+	if job.Result == nil {
+		log.Fatalf("Job %s is %s and has no artifacts yet", jobID, job.State)
+	}
+	if job.Result.Artifacts == nil {
+		fmt.Printf("Job %s left no files in /out\n", jobID)
+		return
+	}
+	if err := downloadArtifacts(client, job.Result, *dir); err != nil {
+		log.Fatalf("Failed to download artifacts of job %s: %v", jobID, err)
+	}
+}
+
+// downloadArtifacts fetches the artifact bundle of a result, checks it against
+// its manifest and unpacks it into dir
+func downloadArtifacts(client *rpcclient.Client, result *modules.JobResult, dir string) error {
+	artifacts := result.Artifacts
+
+	var buf bytes.Buffer
+	h := sha256.New()
+	chunks := result.Overflow["artifacts"].Chunks
+	if err := downloadStream(io.MultiWriter(&buf, h), client, result.JobID, "artifacts", chunks); err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != artifacts.SHA256 {
+		return errors.New("artifact bundle does not match its sha256")
+	}
+
+	var total int64
+	want := make(map[string]string, len(artifacts.Files))
+	for _, f := range artifacts.Files {
+		total += f.Size
+		want[f.Path] = f.SHA256
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	files, err := bundle.Extract(&buf, dir, total)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if want[f.Path] != f.SHA256 {
+			return fmt.Errorf("artifact %s does not match its sha256", f.Path)
+		}
+		fmt.Printf("%s (%d bytes)\n", filepath.Join(dir, filepath.FromSlash(f.Path)), f.Size)
+	}
+	if len(artifacts.Skipped) > 0 {
+		log.Printf("The seller left out %d file(s) over its size limit: %s", len(artifacts.Skipped), strings.Join(artifacts.Skipped, ", "))
+	}
+	return nil
+}
+
+// inputFlag collects repeated -input flags of the form local-path[=path-in-/in]
+type inputFlag []string
+
+func (f *inputFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *inputFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// readInputs builds the inputs of a job from local files and an optional tar bundle
+func readInputs(files []string, bundlePath string) (*modules.JobInputs, error) {
+	if len(files) == 0 && bundlePath == "" {
+		return nil, nil
+	}
+
+	inputs := &modules.JobInputs{}
+	for _, spec := range files {
+		local, name, ok := strings.Cut(spec, "=")
+		if !ok {
+			name = filepath.Base(local)
+		}
+		data, err := os.ReadFile(local)
+		if err != nil {
+			return nil, err
+		}
+		inputs.Files = append(inputs.Files, modules.InputFile{
+			Path:   filepath.ToSlash(name),
+			Data:   base64.StdEncoding.EncodeToString(data),
+			SHA256: bundle.Checksum(data),
+		})
+	}
+	if bundlePath != "" {
+		data, err := os.ReadFile(bundlePath)
+		if err != nil {
+			return nil, err
+		}
+		inputs.Bundle = base64.StdEncoding.EncodeToString(data)
+		inputs.BundleSHA256 = bundle.Checksum(data)
+	}
+
+	// Catch bad paths and oversized inputs before sending the job
+	if _, _, err := inputs.Decode(); err != nil {
+		return nil, err
+	}
+	return inputs, nil
+}
@@ -0,0 +1,71 @@
+package buyer
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/bdr-pro/lumaris/rpcclient"
+)
+
+// BidsMain lists the bids on an auctioned job, or accepts one with -accept
+func BidsMain() {
+	bidsFlags := flag.NewFlagSet("bids", flag.ExitOnError)
+	nakamaServer := bidsFlags.String("server", "127.0.0.1:7350", "Nakama server address")
+	sessionToken := bidsFlags.String("token", "", "Nakama session token")
+	accept := bidsFlags.String("accept", "", "Seller ID whose bid to accept")
+	bidsFlags.Usage = func() {
+		fmt.Println("Usage: lumaris bids [options] <job-id>")
+		bidsFlags.PrintDefaults()
+	}
+	bidsFlags.Parse(os.Args[2:])
+
+	if *sessionToken == "" {
+		log.Fatal("You must provide a session token using -token")
+	}
+	if bidsFlags.NArg() != 1 {
+		bidsFlags.Usage()
+		os.Exit(1)
+	}
+	jobID := bidsFlags.Arg(0)
+
+	client := rpcclient.New(*nakamaServer, *sessionToken, nil)
+	if *accept != "" {
+		if _, err := client.Call("accept_bid", map[string]string{
+			"job_id":    jobID,
+			"seller_id": *accept,
+		}); err != nil {
+			log.Fatalf("Failed to accept bid on job %s: %v", jobID, err)
+		}
+		fmt.Printf("Job %s awarded to seller %s\n", jobID, *accept)
+		return
+	}
+
+	job, err := fetchJob(client, jobID)
+	if err != nil {
+		log.Fatalf("Failed to read job %s: %v", jobID, err)
+	}
+	a := job.Auction
+	if a == nil {
+		log.Fatalf("Job %s is not placed by auction", jobID)
+	}
+
+	switch {
+	case a.Winner != nil:
+		fmt.Printf("Round %d, awarded to %s for %d credits\n", a.Round, a.Winner.SellerID, a.Winner.Price)
+	case time.Now().Unix() < a.ClosesAt:
+		fmt.Printf("Round %d, open until %s\n", a.Round, time.Unix(a.ClosesAt, 0).Format(time.RFC3339))
+	default:
+		fmt.Printf("Round %d, closed\n", a.Round)
+	}
+	if len(a.Bids) == 0 {
+		fmt.Println("No bids yet")
+		return
+	}
+	fmt.Printf("%-38s %8s %12s\n", "SELLER", "PRICE", "ESTIMATE")
+	for _, b := range a.Bids {
+		fmt.Printf("%-38s %8d %12s\n", b.SellerID, b.Price, time.Duration(b.EstimatedSeconds)*time.Second)
+	}
+}
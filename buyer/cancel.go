@@ -0,0 +1,41 @@
+package buyer
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/bdr-pro/lumaris/rpcclient"
+)
+
+// CancelMain cancels a job the buyer submitted
+func CancelMain() {
+	cancelFlags := flag.NewFlagSet("cancel", flag.ExitOnError)
+	nakamaServer := cancelFlags.String("server", "127.0.0.1:7350", "Nakama server address")
+	sessionToken := cancelFlags.String("token", "", "Nakama session token")
+	reason := cancelFlags.String("reason", "", "Reason recorded with the cancellation")
+	cancelFlags.Usage = func() {
+		fmt.Println("Usage: lumaris cancel [options] <job-id>")
+		cancelFlags.PrintDefaults()
+	}
+	cancelFlags.Parse(os.Args[2:])
+
+	if *sessionToken == "" {
+		log.Fatal("You must provide a session token using -token")
+	}
+	if cancelFlags.NArg() != 1 {
+		cancelFlags.Usage()
+		os.Exit(1)
+	}
+	jobID := cancelFlags.Arg(0)
+
+	if _, err := rpcclient.New(*nakamaServer, *sessionToken, nil).Call("cancel_job", map[string]interface{}{
+		"job_id": jobID,
+		"reason": *reason,
+	}); err != nil {
+		log.Fatalf("Failed to cancel job %s: %v", jobID, err)
+	}
+
+	fmt.Printf("Job %s cancelled\n", jobID)
+}
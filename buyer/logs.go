@@ -0,0 +1,108 @@
+package buyer
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/bdr-pro/lumaris/modules"
+	"github.com/bdr-pro/lumaris/rpcclient"
+)
+
+// logPollInterval is how often new log chunks are fetched in follow mode
+const logPollInterval = time.Second
+
+// jobLogs is the response of the get_job_logs RPC
+type jobLogs struct {
+	JobID    string             `json:"job_id"`
+	State    modules.JobState   `json:"state"`
+	Attempt  int                `json:"attempt"`
+	Attempts int                `json:"attempts"`
+	Chunks   []modules.LogChunk `json:"chunks"`
+	AfterSeq int                `json:"after_seq"`
+}
+
+// LogsMain prints the output a job has produced so far, and with -f keeps
+// printing new lines until the job finishes
+func LogsMain() {
+	logsFlags := flag.NewFlagSet("logs", flag.ExitOnError)
+	nakamaServer := logsFlags.String("server", "127.0.0.1:7350", "Nakama server address")
+	sessionToken := logsFlags.String("token", "", "Nakama session token")
+	follow := logsFlags.Bool("f", false, "Keep printing new output until the job finishes")
+	logsFlags.Usage = func() {
+		fmt.Println("Usage: lumaris logs [options] <job-id>")
+		logsFlags.PrintDefaults()
+	}
+	logsFlags.Parse(os.Args[2:])
+
+	if *sessionToken == "" {
+		log.Fatal("You must provide a session token using -token")
+	}
+	if logsFlags.NArg() != 1 {
+		logsFlags.Usage()
+		os.Exit(1)
+	}
+	jobID := logsFlags.Arg(0)
+
+	client := rpcclient.New(*nakamaServer, *sessionToken, nil)
+	attempt, afterSeq := 0, 0
+	for {
+		logs, err := fetchJobLogs(client, jobID, attempt, afterSeq)
+		if err != nil {
+			log.Fatalf("Failed to read logs of job %s: %v", jobID, err)
+		}
+		printLogChunks(logs.Chunks)
+		attempt, afterSeq = logs.Attempt, logs.AfterSeq
+
+		if !*follow {
+			return
+		}
+		if len(logs.Chunks) > 0 {
+			// There may be more chunks waiting, fetch them without sleeping
+			continue
+		}
+		if logs.Attempts > attempt {
+			// The job was requeued and is running again, follow the new attempt
+			log.Printf("Job %s restarted (attempt %d)", jobID, logs.Attempts)
+			attempt, afterSeq = logs.Attempts, 0
+			continue
+		}
+		if logs.State.Terminal() {
+			return
+		}
+		time.Sleep(logPollInterval)
+	}
+}
+
+// fetchJobLogs reads the log chunks of a job attempt after the given sequence number
+func fetchJobLogs(client *rpcclient.Client, jobID string, attempt, afterSeq int) (*jobLogs, error) {
+	body, err := client.Call("get_job_logs", map[string]interface{}{
+		"job_id":    jobID,
+		"attempt":   attempt,
+		"after_seq": afterSeq,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var logs jobLogs
+	if err := json.Unmarshal(body, &logs); err != nil {
+		return nil, fmt.Errorf("invalid get_job_logs response: %w", err)
+	}
+	return &logs, nil
+}
+
+// printLogChunks writes each line to stdout or stderr, matching the stream it came from
+func printLogChunks(chunks []modules.LogChunk) {
+	for _, chunk := range chunks {
+		for _, line := range chunk.Lines {
+			if line.Stream == "stderr" {
+				fmt.Fprintln(os.Stderr, line.Text)
+			} else {
+				fmt.Println(line.Text)
+			}
+		}
+	}
+}
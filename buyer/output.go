@@ -0,0 +1,121 @@
+package buyer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/bdr-pro/lumaris/modules"
+	"github.com/bdr-pro/lumaris/rpcclient"
+)
+
+// OutputMain prints the full stdout and stderr of a finished job, including
+// the part that did not fit inline in its result
+func OutputMain() {
+	outputFlags := flag.NewFlagSet("output", flag.ExitOnError)
+	nakamaServer := outputFlags.String("server", "127.0.0.1:7350", "Nakama server address")
+	sessionToken := outputFlags.String("token", "", "Nakama session token")
+	stream := outputFlags.String("stream", "", "Only print this stream: stdout or stderr")
+	outputFlags.Usage = func() {
+		fmt.Println("Usage: lumaris output [options] <job-id>")
+		outputFlags.PrintDefaults()
+	}
+	outputFlags.Parse(os.Args[2:])
+
+	if *sessionToken == "" {
+		log.Fatal("You must provide a session token using -token")
+	}
+	if outputFlags.NArg() != 1 {
+		outputFlags.Usage()
+		os.Exit(1)
+	}
+	if *stream != "" && *stream != "stdout" && *stream != "stderr" {
+		log.Fatalf("Unknown stream %q, want stdout or stderr", *stream)
+	}
+	jobID := outputFlags.Arg(0)
+
+	client := rpcclient.New(*nakamaServer, *sessionToken, nil)
+	job, err := fetchJob(client, jobID)
+	if err != nil {
+		log.Fatalf("Failed to read job %s: %v", jobID, err)
+	}
+	if job.Result == nil {
+		log.Fatalf("Job %s is %s and has no output yet", jobID, job.State)
+	}
+
+	if *stream == "" || *stream == "stdout" {
+		if err := writeOutput(os.Stdout, client, job.Result, "stdout"); err != nil {
+			log.Fatalf("Failed to download stdout of job %s: %v", jobID, err)
+		}
+	}
+	if *stream == "" || *stream == "stderr" {
+		if err := writeOutput(os.Stderr, client, job.Result, "stderr"); err != nil {
+			log.Fatalf("Failed to download stderr of job %s: %v", jobID, err)
+		}
+	}
+	if job.Result.OutputTruncated {
+		log.Printf("Output of job %s was truncated by the seller", jobID)
+	}
+}
+
+// fetchJob reads the stored record of a job
+func fetchJob(client *rpcclient.Client, jobID string) (*modules.Job, error) {
+	body, err := client.Call("get_job_status", map[string]string{"job_id": jobID})
+	if err != nil {
+		return nil, err
+	}
+	var job modules.Job
+	if err := json.Unmarshal(body, &job); err != nil {
+		return nil, fmt.Errorf("invalid get_job_status response: %w", err)
+	}
+	return &job, nil
+}
+
+// writeOutput writes the inline part of a stream followed by its stored overflow chunks
+func writeOutput(w io.Writer, client *rpcclient.Client, result *modules.JobResult, stream string) error {
+	inline := result.Stdout
+	if stream == "stderr" {
+		inline = result.Stderr
+	}
+	if _, err := io.WriteString(w, inline); err != nil {
+		return err
+	}
+
+	overflow, ok := result.Overflow[stream]
+	if !ok {
+		return nil
+	}
+	return downloadStream(w, client, result.JobID, stream, overflow.Chunks)
+}
+
+// downloadStream writes every stored chunk of a job's output stream to w
+func downloadStream(w io.Writer, client *rpcclient.Client, jobID, stream string, chunks int) error {
+	for i := 0; i < chunks; i++ {
+		body, err := client.Call("get_job_output", map[string]interface{}{
+			"job_id": jobID,
+			"stream": stream,
+			"index":  i,
+		})
+		if err != nil {
+			return err
+		}
+		var chunk struct {
+			Data string `json:"data"`
+		}
+		if err := json.Unmarshal(body, &chunk); err != nil {
+			return fmt.Errorf("invalid get_job_output response: %w", err)
+		}
+		data, err := base64.StdEncoding.DecodeString(chunk.Data)
+		if err != nil {
+			return fmt.Errorf("invalid output chunk %d: %w", i, err)
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
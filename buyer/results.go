@@ -0,0 +1,155 @@
+package buyer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bdr-pro/lumaris/modules"
+	"github.com/bdr-pro/lumaris/realtime"
+	"github.com/bdr-pro/lumaris/rpcclient"
+)
+
+const (
+	// pollInterval is how often the job's status is checked in case the socket misses its result
+	pollInterval = 5 * time.Second
+	// connectTimeout is how long the job waits to be sent until the socket is connected
+	connectTimeout = 5 * time.Second
+)
+
+// resultWaiter receives the result of one job from the realtime socket, with
+// polling of the job's status as a fallback for results sent while the
+// socket was down
+type resultWaiter struct {
+	client  *rpcclient.Client
+	jobID   string
+	results chan *modules.JobResult
+	check   chan struct{} // Asks for an immediate status check, e.g. after a reconnect
+}
+
+// listenForResult connects the realtime socket for the job's result until ctx
+// is done. It returns once the socket is connected, or after connectTimeout,
+// so the job can be sent without its result arriving before anyone listens.
+func listenForResult(ctx context.Context, server, token, jobID string) *resultWaiter {
+	w := &resultWaiter{
+		client:  rpcclient.New(server, token, nil),
+		jobID:   jobID,
+		results: make(chan *modules.JobResult, 1),
+		check:   make(chan struct{}, 1),
+	}
+
+	connected := make(chan struct{})
+	var once sync.Once
+	socket := realtime.NewSocket(server, token)
+	socket.OnNotification = func(n realtime.Notification) {
+		if result := matchResult(n, jobID); result != nil {
+			w.deliver(result)
+		}
+	}
+	socket.OnConnect = func() {
+		once.Do(func() { close(connected) })
+		// A result may have been sent while the socket was reconnecting
+		select {
+		case w.check <- struct{}{}:
+		default:
+		}
+	}
+	go socket.Run(ctx)
+
+	select {
+	case <-connected:
+	case <-ctx.Done():
+	case <-time.After(connectTimeout):
+		log.Printf("Realtime socket not connected yet, relying on polling for the result")
+	}
+	return w
+}
+
+// deliver hands a result to wait, keeping only the first
+func (w *resultWaiter) deliver(result *modules.JobResult) {
+	select {
+	case w.results <- result:
+	default:
+	}
+}
+
+// wait returns the job's result once it arrives
+func (w *resultWaiter) wait(ctx context.Context) (*modules.JobResult, error) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case result := <-w.results:
+			return result, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+			w.poll()
+		case <-w.check:
+			w.poll()
+		}
+	}
+}
+
+// poll reads the job's status and delivers its result once the job is finished
+func (w *resultWaiter) poll() {
+	job, err := fetchJob(w.client, w.jobID)
+	if err != nil {
+		log.Printf("Failed to check job %s: %v", w.jobID, err)
+		return
+	}
+	if job.State.Terminal() && job.Result != nil {
+		w.deliver(job.Result)
+	}
+}
+
+// matchResult decodes a notification as the result of the given job, or returns nil
+func matchResult(n realtime.Notification, jobID string) *modules.JobResult {
+	if n.Code != modules.NotificationJobResult {
+		return nil
+	}
+
+	var content modules.NotificationContent
+	if err := json.Unmarshal([]byte(n.Content), &content); err != nil {
+		return nil
+	}
+	var result modules.JobResult
+	if err := json.Unmarshal(content.Data, &result); err != nil || result.JobID != jobID {
+		return nil
+	}
+	return &result
+}
+
+// printResult prints a job result for the user
+func printResult(result *modules.JobResult) {
+	fmt.Printf("Job %s %s (exit code %d)\n", result.JobID, result.State, result.ExitCode)
+	if result.SellerID != "" {
+		fmt.Printf("Seller: %s\n", result.SellerID)
+	}
+	if result.Error != "" {
+		fmt.Printf("Error: %s\n", result.Error)
+	}
+	if c := result.Consensus; c != nil {
+		fmt.Printf("Verification: %d of %d replicas agreed\n", c.Agreeing, c.Replicas)
+		for _, o := range c.Outcomes {
+			fmt.Printf("  %s  %-9s  seller %s\n", o.JobID, o.Verdict, o.SellerID)
+		}
+	}
+	fmt.Println("Output:")
+	fmt.Print(result.Stdout)
+	fmt.Fprint(os.Stderr, result.Stderr)
+	_, moreStdout := result.Overflow["stdout"]
+	_, moreStderr := result.Overflow["stderr"]
+	if moreStdout || moreStderr {
+		fmt.Printf("\n(output continues, run `lumaris output %s` for all of it)\n", result.JobID)
+	} else if result.OutputTruncated {
+		fmt.Println("\n(output was truncated by the seller)")
+	}
+	if result.Artifacts != nil {
+		fmt.Printf("Artifacts: %d file(s), run `lumaris artifacts %s` to download them\n", len(result.Artifacts.Files), result.JobID)
+	}
+}
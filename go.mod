@@ -6,7 +6,9 @@ toolchain go1.24.2
 
 require (
 	github.com/google/uuid v1.6.0
+	github.com/gorilla/websocket v1.5.3
 	github.com/heroiclabs/nakama-common v1.36.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,128 @@
+// Package imageref parses container image references and matches them against patterns
+package imageref
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// DefaultRegistry is the registry of references that do not name one
+const DefaultRegistry = "docker.io"
+
+// Reference is a fully qualified image reference
+type Reference struct {
+	Registry   string // e.g. "docker.io" or "ghcr.io"
+	Repository string // e.g. "library/python"
+	Tag        string // e.g. "3.10", empty when only a digest is given
+	Digest     string // e.g. "sha256:...", empty unless pinned
+}
+
+// String returns the reference in canonical form
+func (r Reference) String() string {
+	s := r.Registry + "/" + r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// Parse normalizes an image reference the way Docker does: "python:3.10"
+// becomes docker.io/library/python:3.10 and a missing tag means "latest"
+func Parse(image string) (Reference, error) {
+	ref, err := parse(image)
+	if err != nil {
+		return ref, err
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+	if ref.Digest != "" && !strings.HasPrefix(ref.Digest, "sha256:") {
+		return ref, fmt.Errorf("unsupported digest in %q", image)
+	}
+	return ref, nil
+}
+
+// parse splits a reference into its parts without defaulting the tag
+func parse(s string) (Reference, error) {
+	var ref Reference
+	if s == "" || strings.ContainsAny(s, " \t\n") {
+		return ref, fmt.Errorf("invalid image reference %q", s)
+	}
+
+	name := s
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, ref.Digest = name[:i], name[i+1:]
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.Tag = name[:i], name[i+1:]
+	}
+
+	// The first component is a registry only if it looks like a host name
+	if i := strings.Index(name, "/"); i >= 0 {
+		first := name[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			ref.Registry, name = first, name[i+1:]
+		}
+	}
+	if ref.Registry == "" {
+		ref.Registry = DefaultRegistry
+	}
+	if ref.Registry == DefaultRegistry && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	if name == "" {
+		return ref, errors.New("image reference has no repository")
+	}
+	ref.Repository = name
+	return ref, nil
+}
+
+// ValidPattern checks that a pattern can be matched against
+func ValidPattern(pattern string) error {
+	if pattern == "*" {
+		return nil
+	}
+	ref, err := parse(pattern)
+	if err != nil {
+		return err
+	}
+	for _, part := range []string{ref.Registry, ref.Repository, ref.Tag, ref.Digest} {
+		if _, err := path.Match(part, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Match reports whether an image matches a pattern. Both are normalized
+// first, then registry, repository, tag and digest are compared with
+// path.Match, so "python:3.*" matches docker.io/library/python:3.10 and
+// "ghcr.io/acme/*" matches every tag of every repository under ghcr.io/acme.
+// A pattern without a tag or digest matches any. "*" matches every image.
+func Match(pattern, image string) bool {
+	if pattern == "*" {
+		return true
+	}
+	p, err := parse(pattern)
+	if err != nil {
+		return false
+	}
+	ref, err := Parse(image)
+	if err != nil {
+		return false
+	}
+	return glob(p.Registry, ref.Registry) &&
+		glob(p.Repository, ref.Repository) &&
+		(p.Tag == "" || glob(p.Tag, ref.Tag)) &&
+		(p.Digest == "" || glob(p.Digest, ref.Digest))
+}
+
+func glob(pattern, s string) bool {
+	ok, err := path.Match(pattern, s)
+	return err == nil && ok
+}
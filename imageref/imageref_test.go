@@ -0,0 +1,84 @@
+package imageref
+
+import "testing"
+
+const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		image string
+		want  string // Canonical form, empty when the reference is invalid
+	}{
+		{"python", "docker.io/library/python:latest"},
+		{"python:3.10", "docker.io/library/python:3.10"},
+		{"acme/tool:1", "docker.io/acme/tool:1"},
+		{"ghcr.io/acme/tool", "ghcr.io/acme/tool:latest"},
+		{"localhost/tool:dev", "localhost/tool:dev"},
+		{"registry:5000/tool:2", "registry:5000/tool:2"},
+		{"python@" + digest, "docker.io/library/python@" + digest},
+		{"python:3.10@" + digest, "docker.io/library/python:3.10@" + digest},
+		{"", ""},
+		{"python 3", ""},
+		{"python@md5:abc", ""},
+		{"ghcr.io/", ""},
+	}
+	for _, tt := range tests {
+		ref, err := Parse(tt.image)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("Parse(%q) = %s, want an error", tt.image, ref)
+			}
+			continue
+		}
+		if err != nil || ref.String() != tt.want {
+			t.Errorf("Parse(%q) = %s, %v, want %s", tt.image, ref, err, tt.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, image string
+		want           bool
+	}{
+		{"*", "ghcr.io/acme/tool:1", true},
+		{"python:3.10", "python:3.10", true},
+		{"python:3.10", "docker.io/library/python:3.10", true},
+		{"docker.io/library/python:3.10", "python:3.10", true},
+		{"python:3.10", "python:3.11", false},
+		{"python:3.*", "python:3.10", true},
+		{"python:3.*", "python:2.7", false},
+		// A pattern without a tag matches every tag, an image without one is "latest"
+		{"python", "python:3.10", true},
+		{"python", "python@" + digest, true},
+		{"python:latest", "python", true},
+		{"python:3.10", "python", false},
+		{"ghcr.io/acme/*", "ghcr.io/acme/tool:1", true},
+		{"ghcr.io/acme/*", "ghcr.io/acme/team/tool:1", false},
+		{"ghcr.io/acme/*", "docker.io/acme/tool:1", false},
+		{"acme/*", "ghcr.io/acme/tool", false},
+		{"python@" + digest, "python@" + digest, true},
+		{"python@" + digest, "python:3.10", false},
+		{"python@sha256:*", "python:3.10@" + digest, true},
+		{"python[", "python", false},
+		{"python", "not valid", false},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.image); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.image, got, tt.want)
+		}
+	}
+}
+
+func TestValidPattern(t *testing.T) {
+	for _, pattern := range []string{"*", "python:3.*", "ghcr.io/acme/*", "python@sha256:*"} {
+		if err := ValidPattern(pattern); err != nil {
+			t.Errorf("ValidPattern(%q) = %v, want nil", pattern, err)
+		}
+	}
+	for _, pattern := range []string{"", "python:[", "ghcr.io/acme/[", "two words"} {
+		if err := ValidPattern(pattern); err == nil {
+			t.Errorf("ValidPattern(%q) = nil, want an error", pattern)
+		}
+	}
+}
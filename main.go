@@ -23,7 +23,12 @@ func main() {
 		fmt.Println("  main [mode] [options]")
 		fmt.Println("\nModes:")
 		fmt.Println("  buyer    - Run as a buyer to submit compute jobs")
-		fmt.Println("  seller   - Run as a seller to execute compute jobs")
+		fmt.Println("  seller   - Run as a seller to execute compute jobs (seller validate-config checks a config file)")
+		fmt.Println("  cancel   - Cancel a submitted job")
+		fmt.Println("  logs     - Show the output of a job, -f to follow it")
+		fmt.Println("  output   - Download the full stdout and stderr of a finished job")
+		fmt.Println("  artifacts - Download the files a finished job wrote to /out")
+		fmt.Println("  bids     - List the bids on an auctioned job, -accept to award it")
 		fmt.Println("  test-buy - Test the buyer functionality")
 		fmt.Println("  test-sell - Test the seller functionality")
 		fmt.Println("  auth     - Authenticate with Nakama server and get a valid token")
@@ -46,6 +51,21 @@ func main() {
 	case "seller":
 		// Start the seller runner
 		seller.RunnerMain()
+	case "cancel":
+		// Cancel a job
+		buyer.CancelMain()
+	case "logs":
+		// Show job output
+		buyer.LogsMain()
+	case "output":
+		// Download full job output
+		buyer.OutputMain()
+	case "artifacts":
+		// Download job artifacts
+		buyer.ArtifactsMain()
+	case "bids":
+		// Review or accept bids on an auctioned job
+		buyer.BidsMain()
 	case "test-buy":
 		// Run buyer test
 		buyer.Test()
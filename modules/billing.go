@@ -0,0 +1,141 @@
+package modules
+
+import (
+	"errors"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	// CreditsCurrency is the wallet currency jobs are paid in
+	CreditsCurrency = "credits"
+	// EscrowCurrency holds the credits reserved for a buyer's unfinished jobs
+	EscrowCurrency = "escrow"
+)
+
+var errInsufficientCredits = errors.New("not enough credits to cover the job's maximum cost")
+
+// Billing records the credits held and moved for a job
+type Billing struct {
+	Escrow    int64  `json:"escrow"`               // Reserved from the buyer's credits when the job was submitted
+	Paid      int64  `json:"paid"`                 // Paid to the seller on settlement
+	Refunded  int64  `json:"refunded"`             // Returned to the buyer on settlement
+	SellerID  string `json:"seller_id,omitempty"`  // Seller that was paid
+	Reason    string `json:"reason,omitempty"`     // Why the escrow was split the way it was
+	SettledAt int64  `json:"settled_at,omitempty"` // When the escrow was released, zero while it is held
+}
+
+// maxJobCost returns the most any of the sellers could charge for the job
+func maxJobCost(job *JobRequest, sellers []*SellerRecord) int64 {
+	var most int64
+	for _, s := range sellers {
+		if cost := s.Pricing.MaxCost(job.Resources); cost > most {
+			most = cost
+		}
+	}
+	return most
+}
+
+// reserveEscrow moves the given amount from the buyer's credits into escrow
+// when the job is first stored
+func (j *Job) reserveEscrow(amount int64) {
+	if amount == 0 {
+		return
+	}
+	j.walletUpdates = append(j.walletUpdates, &runtime.WalletUpdate{
+		UserID:    j.Request.BuyerID,
+		Changeset: map[string]int64{CreditsCurrency: -amount, EscrowCurrency: amount},
+		Metadata:  map[string]interface{}{"job_id": j.Request.JobID, "type": "escrow_reserve"},
+	})
+}
+
+// settle splits the job's escrow between the seller and the buyer. The
+// seller gets what it earned, up to the escrow, and the buyer the rest.
+// The credits move when the settled job is stored. It does nothing once
+// the job is settled.
+func (j *Job) settle(earned int64, sellerID, reason string) {
+	b := j.Billing
+	if b == nil || b.SettledAt != 0 {
+		return
+	}
+	if earned > b.Escrow {
+		earned = b.Escrow
+	}
+	if earned > 0 {
+		b.SellerID = sellerID
+	}
+	b.Paid = earned
+	b.Refunded = b.Escrow - earned
+	b.Reason = reason
+	b.SettledAt = time.Now().Unix()
+	j.releaseEscrow()
+}
+
+// releaseEscrow moves the credits of a settled job out of the buyer's
+// escrow, to the seller and back to the buyer. The wallets change in the
+// same transaction that stores the job settled, so a settlement is paid
+// out exactly once.
+func (j *Job) releaseEscrow() {
+	b := j.Billing
+	if b.Escrow == 0 {
+		return
+	}
+
+	buyer := map[string]int64{EscrowCurrency: -b.Escrow}
+	if b.Refunded > 0 {
+		buyer[CreditsCurrency] = b.Refunded
+	}
+	j.walletUpdates = append(j.walletUpdates, &runtime.WalletUpdate{
+		UserID:    j.Request.BuyerID,
+		Changeset: buyer,
+		Metadata: map[string]interface{}{
+			"job_id":   j.Request.JobID,
+			"type":     "escrow_release",
+			"paid":     b.Paid,
+			"refunded": b.Refunded,
+			"reason":   b.Reason,
+		},
+	})
+	if b.Paid > 0 {
+		j.walletUpdates = append(j.walletUpdates, &runtime.WalletUpdate{
+			UserID:    b.SellerID,
+			Changeset: map[string]int64{CreditsCurrency: b.Paid},
+			Metadata: map[string]interface{}{
+				"job_id":   j.Request.JobID,
+				"type":     "job_payment",
+				"buyer_id": j.Request.BuyerID,
+			},
+		})
+	}
+}
+
+// sellerAtFault reports whether a result shows the seller never ran the
+// job's container, for example because it refused the image or failed to
+// pull it. The buyer is then refunded in full.
+func sellerAtFault(result *JobResult) bool {
+	return result.Usage == nil || result.Usage.StartedAt == 0
+}
+
+// sellerEarnings works out what the seller is paid for a result, and why.
+// The job is priced at the card agreed when the seller claimed it, and never
+// costs more than the most that card allows for the job's resources.
+func sellerEarnings(job *Job, result *JobResult) (int64, string) {
+	if job.Billing == nil || job.Billing.Escrow == 0 {
+		return 0, "free job"
+	}
+	if sellerAtFault(result) {
+		return 0, "seller did not run the job"
+	}
+	if job.Price == nil || job.Price.SellerID != result.SellerID {
+		return 0, "no price was agreed with the seller"
+	}
+	cost := job.Price.Cost(job.Request.Resources, result.Usage)
+	if cost > job.Price.MaxCost {
+		cost = job.Price.MaxCost
+	}
+	if job.Price.Bid != nil {
+		return cost, "winning bid"
+	}
+	return cost, "metered usage"
+}
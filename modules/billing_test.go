@@ -0,0 +1,54 @@
+package modules
+
+import "testing"
+
+func TestSellerEarningsCappedAtAgreedMaxCost(t *testing.T) {
+	resources := Resources{CPUs: 1, MemoryMB: 1024, TimeoutSeconds: 10}
+	card := PriceCard{CPUSecond: 1, GBSecond: 1}
+	job := &Job{
+		Request: JobRequest{Resources: resources},
+		Billing: &Billing{Escrow: 100},
+		Price:   &AgreedPrice{SellerID: "seller-1", Card: card, MaxCost: card.MaxCost(resources)},
+	}
+
+	tests := []struct {
+		name  string
+		usage *Usage
+		want  int64
+	}{
+		{"metered", &Usage{StartedAt: 1, WallSeconds: 5, CPUSeconds: 3}, 8},
+		{"at the max", &Usage{StartedAt: 1, WallSeconds: 10, CPUSeconds: 10}, 20},
+		// Reported usage beyond the job's resources is not paid for, even though the escrow would cover it
+		{"over the max", &Usage{StartedAt: 1, WallSeconds: 15, CPUSeconds: 50}, 20},
+		{"never started", &Usage{}, 0},
+	}
+	for _, tt := range tests {
+		got, _ := sellerEarnings(job, &JobResult{SellerID: "seller-1", Usage: tt.usage})
+		if got != tt.want {
+			t.Errorf("%s: earned %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	if got, _ := sellerEarnings(job, &JobResult{SellerID: "seller-2", Usage: tests[0].usage}); got != 0 {
+		t.Errorf("want nothing for a seller without an agreed price, got %d", got)
+	}
+}
+
+func TestSettleSplitsEscrow(t *testing.T) {
+	job := &Job{Request: JobRequest{JobID: "job-1", BuyerID: "buyer-1"}, Billing: &Billing{Escrow: 50}}
+	job.settle(80, "seller-1", "metered usage")
+
+	b := job.Billing
+	if b.Paid != 50 || b.Refunded != 0 || b.SellerID != "seller-1" || b.SettledAt == 0 {
+		t.Fatalf("want the payment capped at the escrow, got %+v", b)
+	}
+	if len(job.walletUpdates) != 2 {
+		t.Fatalf("want the buyer's escrow released and the seller paid, got %d updates", len(job.walletUpdates))
+	}
+
+	// A settled job is never paid out twice
+	job.settle(10, "seller-2", "again")
+	if b.Paid != 50 || len(job.walletUpdates) != 2 {
+		t.Errorf("want the second settlement ignored, got %+v", b)
+	}
+}
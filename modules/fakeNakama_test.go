@@ -0,0 +1,159 @@
+package modules
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/heroiclabs/nakama-common/api"
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// fakeNakama keeps storage in memory with Nakama's version checks. Only the
+// calls the module tests reach are implemented; any other call panics on
+// the nil embedded interface.
+type fakeNakama struct {
+	runtime.NakamaModule
+
+	mu      sync.Mutex
+	objects map[string]storedObject // By collection, owner and key
+
+	// beforeWrite, when set, runs at the start of every transactional write,
+	// outside the lock, so tests can hold concurrent writers at the same point
+	beforeWrite func()
+}
+
+func newFakeNakama() *fakeNakama {
+	return &fakeNakama{objects: make(map[string]storedObject)}
+}
+
+// storedObject is one object in the fake storage
+type storedObject struct {
+	collection, userID, key, value, version string
+}
+
+func (o storedObject) api() *api.StorageObject {
+	return &api.StorageObject{Collection: o.collection, Key: o.key, UserId: o.userID, Value: o.value, Version: o.version}
+}
+
+func storageKey(collection, userID, key string) string {
+	return collection + "\x00" + userID + "\x00" + key
+}
+
+// storageVersion hashes a value the way Nakama does, so rewriting the same value keeps the version
+func storageVersion(value string) string {
+	sum := md5.Sum([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+func (f *fakeNakama) StorageRead(ctx context.Context, reads []*runtime.StorageRead) ([]*api.StorageObject, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var objects []*api.StorageObject
+	for _, r := range reads {
+		if obj, ok := f.objects[storageKey(r.Collection, r.UserID, r.Key)]; ok {
+			objects = append(objects, obj.api())
+		}
+	}
+	return objects, nil
+}
+
+func (f *fakeNakama) StorageList(ctx context.Context, callerID, userID, collection string, limit int, cursor string) ([]*api.StorageObject, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var matching []storedObject
+	for _, obj := range f.objects {
+		if obj.collection == collection && obj.userID == userID {
+			matching = append(matching, obj)
+		}
+	}
+	sort.Slice(matching, func(i, j int) bool { return matching[i].key < matching[j].key })
+
+	start, _ := strconv.Atoi(cursor)
+	end := start + limit
+	if end >= len(matching) {
+		end, cursor = len(matching), ""
+	} else {
+		cursor = strconv.Itoa(end)
+	}
+	var page []*api.StorageObject
+	for _, obj := range matching[start:end] {
+		page = append(page, obj.api())
+	}
+	return page, cursor, nil
+}
+
+func (f *fakeNakama) StorageWrite(ctx context.Context, writes []*runtime.StorageWrite) ([]*api.StorageObjectAck, error) {
+	acks, _, err := f.MultiUpdate(ctx, nil, writes, nil, nil, false)
+	return acks, err
+}
+
+func (f *fakeNakama) StorageDelete(ctx context.Context, deletes []*runtime.StorageDelete) error {
+	_, _, err := f.MultiUpdate(ctx, nil, nil, deletes, nil, false)
+	return err
+}
+
+// MultiUpdate applies every write and delete, or none if a version check fails.
+// Wallets are not modelled.
+func (f *fakeNakama) MultiUpdate(ctx context.Context, accountUpdates []*runtime.AccountUpdate, storageWrites []*runtime.StorageWrite, storageDeletes []*runtime.StorageDelete, walletUpdates []*runtime.WalletUpdate, updateLedger bool) ([]*api.StorageObjectAck, []*runtime.WalletUpdateResult, error) {
+	if f.beforeWrite != nil {
+		f.beforeWrite()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, w := range storageWrites {
+		existing, ok := f.objects[storageKey(w.Collection, w.UserID, w.Key)]
+		switch {
+		case w.Version == "":
+		case w.Version == "*" && ok:
+			return nil, nil, runtime.ErrStorageRejectedVersion
+		case w.Version != "*" && (!ok || existing.version != w.Version):
+			return nil, nil, runtime.ErrStorageRejectedVersion
+		}
+	}
+	for _, d := range storageDeletes {
+		existing, ok := f.objects[storageKey(d.Collection, d.UserID, d.Key)]
+		if d.Version != "" && (!ok || existing.version != d.Version) {
+			return nil, nil, runtime.ErrStorageRejectedVersion
+		}
+	}
+
+	acks := make([]*api.StorageObjectAck, 0, len(storageWrites))
+	for _, w := range storageWrites {
+		obj := storedObject{collection: w.Collection, userID: w.UserID, key: w.Key, value: w.Value, version: storageVersion(w.Value)}
+		f.objects[storageKey(w.Collection, w.UserID, w.Key)] = obj
+		acks = append(acks, &api.StorageObjectAck{Collection: w.Collection, Key: w.Key, UserId: w.UserID, Version: obj.version})
+	}
+	for _, d := range storageDeletes {
+		delete(f.objects, storageKey(d.Collection, d.UserID, d.Key))
+	}
+	return acks, nil, nil
+}
+
+func (f *fakeNakama) NotificationsSend(ctx context.Context, notifications []*runtime.NotificationSend) error {
+	return nil
+}
+
+func (f *fakeNakama) LeaderboardRecordWrite(ctx context.Context, id, ownerID, username string, score, subscore int64, metadata map[string]interface{}, overrideOperator *int) (*api.LeaderboardRecord, error) {
+	return &api.LeaderboardRecord{}, nil
+}
+
+// fakeLogger discards everything logged
+type fakeLogger struct{}
+
+func (fakeLogger) Debug(format string, v ...interface{})                     {}
+func (fakeLogger) Info(format string, v ...interface{})                      {}
+func (fakeLogger) Warn(format string, v ...interface{})                      {}
+func (fakeLogger) Error(format string, v ...interface{})                     {}
+func (l fakeLogger) WithField(key string, v interface{}) runtime.Logger      { return l }
+func (l fakeLogger) WithFields(fields map[string]interface{}) runtime.Logger { return l }
+func (fakeLogger) Fields() map[string]interface{}                            { return nil }
+
+// asUser returns a context of an RPC called by the user
+func asUser(userID string) context.Context {
+	return context.WithValue(context.Background(), runtime.RUNTIME_CTX_USER_ID, userID)
+}
@@ -0,0 +1,33 @@
+package modules
+
+import (
+	"context"
+	"errors"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+var (
+	errNoSession        = errors.New("this RPC requires an authenticated user session")
+	errIdentityMismatch = errors.New("payload user ID does not match the session user")
+)
+
+// callerID returns the user ID of the session making the RPC call, or "" for server-to-server calls
+func callerID(ctx context.Context) string {
+	userID, _ := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string)
+	return userID
+}
+
+// sessionUserID returns the caller's user ID. Client-supplied IDs are never
+// trusted: an empty claim is filled in from the session and a claim naming
+// another user is rejected.
+func sessionUserID(ctx context.Context, claimed string) (string, error) {
+	userID := callerID(ctx)
+	if userID == "" {
+		return "", errNoSession
+	}
+	if claimed != "" && claimed != userID {
+		return "", errIdentityMismatch
+	}
+	return userID, nil
+}
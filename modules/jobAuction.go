@@ -0,0 +1,349 @@
+package modules
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// Rules for choosing the winning bid of an auction
+const (
+	AwardCheapest   = "cheapest"   // Lowest price
+	AwardFastest    = "fastest"    // Earliest estimated completion
+	AwardReputation = "reputation" // Best seller reputation
+	AwardManual     = "manual"     // The buyer accepts a bid with accept_bid
+)
+
+const (
+	// defaultBidWindow is how long (in seconds) sellers may bid when the buyer does not say
+	defaultBidWindow = 30
+	// maxBidWindow caps the bid window a buyer may ask for
+	maxBidWindow = 300
+)
+
+var (
+	errNotAuctioned  = errors.New("job is not placed by auction")
+	errBiddingClosed = errors.New("bidding on this job is closed")
+)
+
+// AuctionOptions asks for a job to be placed by auction rather than offered
+// to every eligible seller at its price card
+type AuctionOptions struct {
+	Rule          string `json:"rule,omitempty"`           // cheapest (default), fastest, reputation or manual
+	WindowSeconds int64  `json:"window_seconds,omitempty"` // How long sellers may bid, 30 by default
+}
+
+// Validate checks the options and fills in the defaults
+func (o *AuctionOptions) Validate() error {
+	if o == nil {
+		return nil
+	}
+	switch o.Rule {
+	case "":
+		o.Rule = AwardCheapest
+	case AwardCheapest, AwardFastest, AwardReputation, AwardManual:
+	default:
+		return fmt.Errorf("unknown auction rule %q (want cheapest, fastest, reputation or manual)", o.Rule)
+	}
+	if o.WindowSeconds < 0 || o.WindowSeconds > maxBidWindow {
+		return fmt.Errorf("auction window_seconds must be between 0 and %d", maxBidWindow)
+	}
+	if o.WindowSeconds == 0 {
+		o.WindowSeconds = defaultBidWindow
+	}
+	return nil
+}
+
+// Bid is a seller's offer to run an auctioned job for a flat price
+type Bid struct {
+	SellerID         string `json:"seller_id"`
+	Price            int64  `json:"price"`             // Credits charged for the whole job
+	EstimatedSeconds int64  `json:"estimated_seconds"` // Expected time until the job is done, including any wait for a slot
+	At               int64  `json:"at"`                // When the bid was placed
+}
+
+// Auction is the bidding state of a job placed by auction
+type Auction struct {
+	Round    int   `json:"round"`            // Bidding rounds so far
+	ClosesAt int64 `json:"closes_at"`        // When the current round stops taking bids
+	Bids     []Bid `json:"bids"`             // Bids placed in the current round
+	Winner   *Bid  `json:"winner,omitempty"` // Bid the job was awarded to
+}
+
+// AuctionAnnouncement is sent to the eligible sellers when a bidding round opens
+type AuctionAnnouncement struct {
+	Job      JobRequest `json:"job"`
+	Rule     string     `json:"rule"`
+	ClosesAt int64      `json:"closes_at"`
+}
+
+// due reports whether the sweeper has to act on the auction: award it,
+// open a new round because nobody bid, or give up on a winner that never claimed
+func (a *Auction) due(rule string, offeredAt, now int64) bool {
+	switch {
+	case a.Winner != nil:
+		return now-offeredAt >= reofferInterval
+	case now < a.ClosesAt:
+		return false
+	case len(a.Bids) == 0:
+		return true
+	}
+	return rule != AwardManual
+}
+
+// openRound starts a new bidding round among the given sellers
+func (j *Job) openRound(sellers []*SellerRecord) {
+	if j.Auction == nil {
+		j.Auction = &Auction{}
+	}
+	j.markOffered(sellers)
+	j.Auction.Round++
+	j.Auction.ClosesAt = j.OfferedAt + j.Request.Auction.WindowSeconds
+	j.Auction.Bids = nil
+	j.Auction.Winner = nil
+}
+
+// openAuction stores a job with a new bidding round and announces it to the sellers
+func openAuction(ctx context.Context, nk runtime.NakamaModule, job *Job, version string, sellers []*SellerRecord) error {
+	job.openRound(sellers)
+	if err := writeJob(ctx, nk, job, version); err != nil {
+		return err
+	}
+	if len(sellers) == 0 {
+		return nil
+	}
+	return announceAuction(ctx, nk, job, sellers)
+}
+
+// announceAuction invites each of the sellers to bid on the job
+func announceAuction(ctx context.Context, nk runtime.NakamaModule, job *Job, sellers []*SellerRecord) error {
+	content := map[string]interface{}{
+		"type": "job_auction",
+		"data": AuctionAnnouncement{
+			Job:      job.Request,
+			Rule:     job.Request.Auction.Rule,
+			ClosesAt: job.Auction.ClosesAt,
+		},
+	}
+
+	notifications := make([]*runtime.NotificationSend, 0, len(sellers))
+	for _, s := range sellers {
+		notifications = append(notifications, &runtime.NotificationSend{
+			UserID:     s.UserID,
+			Subject:    "Job Auction",
+			Content:    content,
+			Code:       NotificationJobAuction,
+			Persistent: false,
+		})
+	}
+	return nk.NotificationsSend(ctx, notifications)
+}
+
+// BidJob records the calling seller's bid on an auctioned job. A seller may
+// bid again while the round is open; its latest bid counts.
+func BidJob(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req struct {
+		JobID            string `json:"job_id"`
+		SellerID         string `json:"seller_id"`
+		Price            int64  `json:"price"`
+		EstimatedSeconds int64  `json:"estimated_seconds"`
+	}
+	if err := json.Unmarshal([]byte(payload), &req); err != nil {
+		return "", errors.New("invalid bid_job request format")
+	}
+	if req.JobID == "" {
+		return "", errors.New("bid_job requires a job_id")
+	}
+	if req.Price < 0 || req.EstimatedSeconds <= 0 {
+		return "", errors.New("a bid needs a price of at least 0 and a positive estimated_seconds")
+	}
+	sellerID, err := sessionUserID(ctx, req.SellerID)
+	if err != nil {
+		return "", err
+	}
+
+	job, version, err := readJob(ctx, nk, req.JobID)
+	if err == errJobNotFound {
+		return "", err
+	}
+	if err != nil {
+		logger.Error("Failed to read job %s: %v", req.JobID, err)
+		return "", errors.New("failed to place bid")
+	}
+	if job.Auction == nil {
+		return "", errNotAuctioned
+	}
+	now := time.Now().Unix()
+	if job.State != JobQueued || job.Auction.Winner != nil || now >= job.Auction.ClosesAt {
+		return "", errBiddingClosed
+	}
+	if !contains(job.OfferedTo, sellerID) {
+		return "", errors.New("job was not announced to this seller")
+	}
+	if req.Price > job.Request.MaxPrice {
+		return "", fmt.Errorf("bid is above the job's max_price of %d", job.Request.MaxPrice)
+	}
+
+	bid := Bid{SellerID: sellerID, Price: req.Price, EstimatedSeconds: req.EstimatedSeconds, At: now}
+	bids := job.Auction.Bids[:0]
+	for _, b := range job.Auction.Bids {
+		if b.SellerID != sellerID {
+			bids = append(bids, b)
+		}
+	}
+	job.Auction.Bids = append(bids, bid)
+
+	if err := writeJob(ctx, nk, job, version); err == errJobConflict {
+		return "", err
+	} else if err != nil {
+		logger.Error("Failed to store bid on job %s: %v", req.JobID, err)
+		return "", errors.New("failed to place bid")
+	}
+
+	logger.Info("Seller %s bid %d credits on job %s", sellerID, req.Price, req.JobID)
+	out, err := json.Marshal(map[string]interface{}{
+		"job_id":    job.Request.JobID,
+		"round":     job.Auction.Round,
+		"closes_at": job.Auction.ClosesAt,
+	})
+	if err != nil {
+		return "", errors.New("failed to encode bid")
+	}
+	return string(out), nil
+}
+
+// AcceptBid awards an auctioned job to the bid of the seller the buyer picked
+func AcceptBid(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req struct {
+		JobID    string `json:"job_id"`
+		SellerID string `json:"seller_id"`
+	}
+	if err := json.Unmarshal([]byte(payload), &req); err != nil || req.JobID == "" || req.SellerID == "" {
+		return "", errors.New("accept_bid requires a job_id and a seller_id")
+	}
+
+	userID := callerID(ctx)
+	if userID == "" {
+		return "", errNoSession
+	}
+
+	job, version, err := readJob(ctx, nk, req.JobID)
+	if err == errJobNotFound {
+		return "", err
+	}
+	if err != nil {
+		logger.Error("Failed to read job %s: %v", req.JobID, err)
+		return "", errors.New("failed to accept bid")
+	}
+	if job.Request.BuyerID != userID {
+		return "", errors.New("only the buyer of a job may accept a bid")
+	}
+	if job.Auction == nil {
+		return "", errNotAuctioned
+	}
+	if job.State != JobQueued || job.Auction.Winner != nil {
+		return "", errBiddingClosed
+	}
+
+	for _, bid := range job.Auction.Bids {
+		if bid.SellerID == req.SellerID {
+			if err := awardBid(ctx, nk, job, version, bid); err == errJobConflict {
+				return "", err
+			} else if err != nil {
+				logger.Error("Failed to award job %s: %v", req.JobID, err)
+				return "", errors.New("failed to accept bid")
+			}
+			logger.Info("Buyer accepted the bid of seller %s on job %s", bid.SellerID, req.JobID)
+			return marshalJobState(job)
+		}
+	}
+	return "", errors.New("seller has no bid on this job")
+}
+
+// awardBid gives the job to the winning bid and offers it to that seller alone
+func awardBid(ctx context.Context, nk runtime.NakamaModule, job *Job, version string, bid Bid) error {
+	winner := []*SellerRecord{{UserID: bid.SellerID}}
+	job.Auction.Winner = &bid
+	job.markOffered(winner)
+	if err := writeJob(ctx, nk, job, version); err != nil {
+		return err
+	}
+	return offerJob(ctx, nk, &job.Request, winner)
+}
+
+// sweepAuction awards an auction whose bidding round is over, or opens a new
+// round when nobody bid or the winner did not claim the job in time
+func sweepAuction(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, job *Job, version string) error {
+	a := job.Auction
+	if a.Winner != nil {
+		logger.Warn("Seller %s did not claim job %s it won, opening bidding again", a.Winner.SellerID, job.Request.JobID)
+		return reofferJob(ctx, nk, job, version, []string{a.Winner.SellerID})
+	}
+	if len(a.Bids) == 0 {
+		return reofferJob(ctx, nk, job, version, nil)
+	}
+
+	bidders := make([]string, 0, len(a.Bids))
+	for _, b := range a.Bids {
+		bidders = append(bidders, b.SellerID)
+	}
+	scores, err := sellerReputations(ctx, nk, bidders)
+	if err != nil {
+		return err
+	}
+	bid := bestBid(a.Bids, job.Request.Auction.Rule, scores)
+	logger.Info("Job %s awarded to seller %s for %d credits", job.Request.JobID, bid.SellerID, bid.Price)
+	return awardBid(ctx, nk, job, version, bid)
+}
+
+// bestBid picks the winning bid by the rule, breaking ties by price and then by who bid first
+func bestBid(bids []Bid, rule string, reputation map[string]float64) Bid {
+	best := bids[0]
+	for _, b := range bids[1:] {
+		if betterBid(b, best, rule, reputation) {
+			best = b
+		}
+	}
+	return best
+}
+
+// betterBid reports whether bid a beats bid b under the rule
+func betterBid(a, b Bid, rule string, reputation map[string]float64) bool {
+	switch rule {
+	case AwardFastest:
+		if a.EstimatedSeconds != b.EstimatedSeconds {
+			return a.EstimatedSeconds < b.EstimatedSeconds
+		}
+	case AwardReputation:
+		if reputation[a.SellerID] != reputation[b.SellerID] {
+			return reputation[a.SellerID] > reputation[b.SellerID]
+		}
+	}
+	if a.Price != b.Price {
+		return a.Price < b.Price
+	}
+	return a.At < b.At
+}
+
+// sealedFor returns the job as the user may see it. Only the buyer sees
+// every bid; a seller sees its own bid and, once awarded, the winner.
+func (j *Job) sealedFor(userID string) *Job {
+	if j.Auction == nil || j.Request.BuyerID == userID {
+		return j
+	}
+	sealed := *j
+	auction := *j.Auction
+	auction.Bids = nil
+	for _, b := range j.Auction.Bids {
+		if b.SellerID == userID {
+			auction.Bids = append(auction.Bids, b)
+		}
+	}
+	sealed.Auction = &auction
+	return &sealed
+}
@@ -0,0 +1,79 @@
+package modules
+
+import "testing"
+
+func TestBestBid(t *testing.T) {
+	bids := []Bid{
+		{SellerID: "a", Price: 50, EstimatedSeconds: 30, At: 1},
+		{SellerID: "b", Price: 20, EstimatedSeconds: 90, At: 2},
+		{SellerID: "c", Price: 80, EstimatedSeconds: 10, At: 3},
+	}
+	reputation := map[string]float64{"a": 0.9, "b": 0.4, "c": 0.7}
+
+	tests := []struct {
+		rule string
+		want string
+	}{
+		{AwardCheapest, "b"},
+		{AwardFastest, "c"},
+		{AwardReputation, "a"},
+	}
+	for _, tt := range tests {
+		if got := bestBid(bids, tt.rule, reputation); got.SellerID != tt.want {
+			t.Errorf("%s: got seller %s, want %s", tt.rule, got.SellerID, tt.want)
+		}
+	}
+}
+
+func TestBestBidTieBreaks(t *testing.T) {
+	tests := []struct {
+		name       string
+		rule       string
+		bids       []Bid
+		reputation map[string]float64
+		want       string
+	}{
+		{
+			name: "cheapest tie goes to the first bid",
+			rule: AwardCheapest,
+			bids: []Bid{{SellerID: "late", Price: 10, At: 9}, {SellerID: "early", Price: 10, At: 4}},
+			want: "early",
+		},
+		{
+			name: "fastest tie goes to the cheaper bid",
+			rule: AwardFastest,
+			bids: []Bid{{SellerID: "dear", Price: 30, EstimatedSeconds: 60, At: 1}, {SellerID: "cheap", Price: 20, EstimatedSeconds: 60, At: 2}},
+			want: "cheap",
+		},
+		{
+			name: "fastest tie on price goes to the first bid",
+			rule: AwardFastest,
+			bids: []Bid{{SellerID: "late", Price: 20, EstimatedSeconds: 60, At: 5}, {SellerID: "early", Price: 20, EstimatedSeconds: 60, At: 2}},
+			want: "early",
+		},
+		{
+			name:       "reputation tie goes to the cheaper bid",
+			rule:       AwardReputation,
+			bids:       []Bid{{SellerID: "dear", Price: 30, At: 1}, {SellerID: "cheap", Price: 20, At: 2}},
+			reputation: map[string]float64{"dear": 0.8, "cheap": 0.8},
+			want:       "cheap",
+		},
+		{
+			name: "sellers without a score tie",
+			rule: AwardReputation,
+			bids: []Bid{{SellerID: "x", Price: 20, At: 3}, {SellerID: "y", Price: 20, At: 1}},
+			want: "y",
+		},
+		{
+			name: "single bid wins",
+			rule: AwardFastest,
+			bids: []Bid{{SellerID: "only", Price: 99, EstimatedSeconds: 999}},
+			want: "only",
+		},
+	}
+	for _, tt := range tests {
+		if got := bestBid(tt.bids, tt.rule, tt.reputation); got.SellerID != tt.want {
+			t.Errorf("%s: got seller %s, want %s", tt.name, got.SellerID, tt.want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package modules
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// CancelJob stops a job at the request of the buyer who submitted it. The
+// seller running it is told to kill its container and the buyer receives a
+// final cancelled result.
+func CancelJob(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req struct {
+		JobID  string `json:"job_id"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(payload), &req); err != nil || req.JobID == "" {
+		return "", errors.New("cancel_job requires a job_id")
+	}
+
+	userID := callerID(ctx)
+	if userID == "" {
+		return "", errNoSession
+	}
+
+	job, version, err := readJob(ctx, nk, req.JobID)
+	if err == errJobNotFound {
+		return "", err
+	}
+	if err != nil {
+		logger.Error("Failed to read job %s: %v", req.JobID, err)
+		return "", errors.New("failed to cancel job")
+	}
+	if job.Request.BuyerID != userID {
+		return "", errors.New("only the buyer of a job may cancel it")
+	}
+	if job.State.Terminal() {
+		return "", fmt.Errorf("job is already %s", job.State)
+	}
+	if job.ReplicaOf != "" {
+		return "", fmt.Errorf("job is a replica of %s; cancel that job instead", job.ReplicaOf)
+	}
+
+	reason := "cancelled by buyer"
+	if req.Reason != "" {
+		reason += ": " + req.Reason
+	}
+	result, err := cancelJob(ctx, logger, nk, job, version, reason)
+	if err == errJobConflict {
+		return "", err
+	} else if err != nil {
+		logger.Error("Failed to store cancellation of job %s: %v", req.JobID, err)
+		return "", errors.New("failed to cancel job")
+	}
+	if len(job.Replicas) > 0 {
+		cancelReplicas(ctx, logger, nk, job, reason)
+	}
+
+	if err := notifyBuyer(ctx, nk, result, "Job Cancelled"); err != nil {
+		logger.Error("Failed to send notification to buyer: %v", err)
+	}
+
+	logger.Info("Job %s cancelled by buyer %s", job.Request.JobID, userID)
+	return marshalJobState(job)
+}
+
+// cancelJob moves a job to the cancelled state, settles it and tells its
+// seller, if it has one, to stop running it
+func cancelJob(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, job *Job, version, reason string) (*JobResult, error) {
+	if err := job.transition(JobCancelled, reason); err != nil {
+		return nil, err
+	}
+
+	result := &JobResult{
+		JobID:     job.Request.JobID,
+		BuyerID:   job.Request.BuyerID,
+		SellerID:  job.SellerID,
+		Error:     "job " + reason,
+		ExitCode:  -1,
+		Timestamp: time.Now().Unix(),
+		State:     JobCancelled,
+	}
+	job.Result = result
+	job.LeaseExpiresAt = 0
+
+	// A seller that already took the job keeps its per-job minimum; the rest is refunded
+	var earned int64
+	if job.SellerID != "" && job.Price != nil && job.Price.SellerID == job.SellerID {
+		earned = job.Price.CancellationFee(job.Request.Resources)
+	}
+	job.settle(earned, job.SellerID, reason)
+
+	if err := writeJob(ctx, nk, job, version); err != nil {
+		return nil, err
+	}
+
+	// The seller also finds out through its next lease renewal if this notification is missed
+	if job.SellerID != "" {
+		if err := notifySellerCancel(ctx, nk, job.SellerID, job.Request.JobID, reason); err != nil {
+			logger.Error("Failed to tell seller %s to cancel job %s: %v", job.SellerID, job.Request.JobID, err)
+		}
+	}
+	return result, nil
+}
+
+// notifySellerCancel tells the seller running a job to stop it
+func notifySellerCancel(ctx context.Context, nk runtime.NakamaModule, sellerID, jobID, reason string) error {
+	content := map[string]interface{}{
+		"type": "job_cancel",
+		"data": map[string]string{"job_id": jobID, "reason": reason},
+	}
+	return nk.NotificationSend(ctx, sellerID, "Job Cancelled", content, NotificationJobCancel, "", false)
+}
+
+// marshalJobState builds a short response describing where a job stands
+func marshalJobState(job *Job) (string, error) {
+	out, err := json.Marshal(map[string]interface{}{
+		"job_id": job.Request.JobID,
+		"state":  job.State,
+	})
+	if err != nil {
+		return "", errors.New("failed to encode job state")
+	}
+	return string(out), nil
+}
@@ -0,0 +1,117 @@
+package modules
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/bdr-pro/lumaris/bundle"
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	// MaxInputBytes caps the decoded size of all inputs carried by a job request
+	MaxInputBytes = 1 << 20
+
+	// jobInputsCollection holds the inputs of each job apart from its record,
+	// so they reach only the seller holding the lease
+	jobInputsCollection = "job_inputs"
+)
+
+// InputFile is a file placed in the job's /in directory
+type InputFile struct {
+	Path   string `json:"path"`   // Path relative to /in
+	Data   string `json:"data"`   // Base64 encoded content
+	SHA256 string `json:"sha256"` // Hex checksum of the decoded content
+}
+
+// JobInputs are the files a job is given, mounted read-only at /in
+type JobInputs struct {
+	Files []InputFile `json:"files,omitempty"`
+	// Bundle is a base64 encoded tar archive, optionally gzip compressed,
+	// unpacked into /in alongside the files
+	Bundle       string `json:"bundle,omitempty"`
+	BundleSHA256 string `json:"bundle_sha256,omitempty"` // Hex checksum of the decoded bundle
+}
+
+// Empty reports whether there is nothing to mount
+func (in *JobInputs) Empty() bool {
+	return in == nil || (len(in.Files) == 0 && in.Bundle == "")
+}
+
+// Decode checks every path, size and checksum and returns the decoded files
+// keyed by path, along with the decoded bundle
+func (in *JobInputs) Decode() (files map[string][]byte, tarball []byte, err error) {
+	if in == nil {
+		return nil, nil, nil
+	}
+
+	var total int
+	files = make(map[string][]byte, len(in.Files))
+	for _, f := range in.Files {
+		name, err := bundle.CleanPath(f.Path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("input file: %w", err)
+		}
+		if _, dup := files[name]; dup {
+			return nil, nil, fmt.Errorf("input file %s is listed twice", name)
+		}
+		data, err := base64.StdEncoding.DecodeString(f.Data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("input file %s is not valid base64", name)
+		}
+		if f.SHA256 == "" || bundle.Checksum(data) != f.SHA256 {
+			return nil, nil, fmt.Errorf("input file %s does not match its sha256", name)
+		}
+		total += len(data)
+		files[name] = data
+	}
+
+	if in.Bundle != "" {
+		tarball, err = base64.StdEncoding.DecodeString(in.Bundle)
+		if err != nil {
+			return nil, nil, errors.New("input bundle is not valid base64")
+		}
+		if in.BundleSHA256 == "" || bundle.Checksum(tarball) != in.BundleSHA256 {
+			return nil, nil, errors.New("input bundle does not match its sha256")
+		}
+		total += len(tarball)
+	}
+
+	if total > MaxInputBytes {
+		return nil, nil, fmt.Errorf("inputs exceed %d bytes", MaxInputBytes)
+	}
+	return files, tarball, nil
+}
+
+// readJobInputs loads the inputs of a job, or nil if it has none. The
+// replicas of a verified job share the inputs stored for it.
+func readJobInputs(ctx context.Context, nk runtime.NakamaModule, job *Job) (*JobInputs, error) {
+	key := job.Request.JobID
+	if job.ReplicaOf != "" {
+		key = job.ReplicaOf
+	}
+	objects, err := nk.StorageRead(ctx, []*runtime.StorageRead{{Collection: jobInputsCollection, Key: key}})
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 {
+		return nil, nil
+	}
+	var inputs JobInputs
+	if err := json.Unmarshal([]byte(objects[0].Value), &inputs); err != nil {
+		return nil, err
+	}
+	return &inputs, nil
+}
+
+// Artifacts describes the files a job left in /out. The gzip compressed
+// tar of them is stored as the "artifacts" output stream.
+type Artifacts struct {
+	Files   []bundle.File `json:"files"`             // Files in the bundle
+	Bytes   int64         `json:"bytes"`             // Size of the compressed bundle
+	SHA256  string        `json:"sha256"`            // Hex checksum of the compressed bundle
+	Skipped []string      `json:"skipped,omitempty"` // Files left out because of the seller's size limit
+}
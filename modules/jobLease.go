@@ -0,0 +1,454 @@
+package modules
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	// JobLeaseDuration is how long (in seconds) a claimed job belongs to its seller without a renewal
+	JobLeaseDuration = 60
+	// MaxJobAttempts is how many times a job is claimed before the server gives up on it
+	MaxJobAttempts = 3
+
+	// maxQueueSeconds is how long a job may wait in the queue before it expires
+	maxQueueSeconds = 600
+	// reofferInterval is how long (in seconds) a queued job waits before it is offered again
+	reofferInterval = 30
+	// sweepInterval is how often the sweeper looks for expired leases
+	sweepInterval = 10 * time.Second
+)
+
+// JobLeaseLostMessage is the error of an RPC from a seller that no longer
+// holds the job's lease; the seller should stop running the job
+const JobLeaseLostMessage = "lease on this job is no longer held by this seller"
+
+var (
+	errJobAlreadyClaimed = errors.New("job has already been claimed by another seller")
+	errJobLeaseLost      = errors.New(JobLeaseLostMessage)
+)
+
+// ClaimJob gives the calling seller an exclusive lease on a queued job.
+// The claim is a version-checked write, so of several sellers racing for
+// the same job exactly one succeeds. A replica's claim is also recorded on
+// its verified job in the same write, so one seller racing for two replicas
+// gets at most one of them.
+func ClaimJob(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req struct {
+		JobID    string `json:"job_id"`
+		SellerID string `json:"seller_id"`
+	}
+	if err := json.Unmarshal([]byte(payload), &req); err != nil {
+		return "", errors.New("invalid claim_job request format")
+	}
+	if req.JobID == "" {
+		return "", errors.New("claim_job requires a job_id")
+	}
+	sellerID, err := sessionUserID(ctx, req.SellerID)
+	if err != nil {
+		return "", err
+	}
+	req.SellerID = sellerID
+
+	job, version, err := readJob(ctx, nk, req.JobID)
+	if err == errJobNotFound {
+		return "", err
+	}
+	if err != nil {
+		logger.Error("Failed to read job %s: %v", req.JobID, err)
+		return "", errors.New("failed to claim job")
+	}
+
+	if job.State != JobQueued {
+		if job.SellerID != "" && job.SellerID != req.SellerID {
+			return "", errJobAlreadyClaimed
+		}
+		return "", fmt.Errorf("job is %s and cannot be claimed", job.State)
+	}
+	if a := job.Auction; a != nil && (a.Winner == nil || a.Winner.SellerID != req.SellerID) {
+		return "", errors.New("job is placed by auction and was not awarded to this seller")
+	}
+	if !contains(job.OfferedTo, req.SellerID) {
+		return "", errors.New("job was not offered to this seller")
+	}
+	// The replicas of a verified job must each run on a different seller
+	var (
+		parent        *Job
+		parentVersion string
+	)
+	if job.ReplicaOf != "" {
+		parent, parentVersion, err = readJob(ctx, nk, job.ReplicaOf)
+		if err != nil {
+			logger.Error("Failed to read verified job %s: %v", job.ReplicaOf, err)
+			return "", errors.New("failed to claim job")
+		}
+		if parent.State.Terminal() {
+			return "", fmt.Errorf("verified job is %s", parent.State)
+		}
+		if contains(parent.sellersBesides(req.JobID), req.SellerID) {
+			return "", errors.New("seller already runs another replica of this job")
+		}
+	}
+
+	// Fix the price now, from the seller's current card, so it cannot change under the job
+	seller, err := readSeller(ctx, nk, req.SellerID)
+	if err == errSellerNotRegistered {
+		return "", err
+	}
+	if err != nil {
+		logger.Error("Failed to read seller %s: %v", req.SellerID, err)
+		return "", errors.New("failed to claim job")
+	}
+	price := agreePrice(job, seller)
+	if job.Request.MaxPrice > 0 && price.MaxCost > job.Request.MaxPrice {
+		return "", fmt.Errorf("seller's price of up to %d credits is above the job's max_price of %d", price.MaxCost, job.Request.MaxPrice)
+	}
+	if job.Billing != nil && price.MaxCost > job.Billing.Escrow {
+		return "", fmt.Errorf("seller's price of up to %d credits is above the %d credits held for the job", price.MaxCost, job.Billing.Escrow)
+	}
+
+	// Inputs are only handed out with the lease
+	inputs, err := readJobInputs(ctx, nk, job)
+	if err != nil {
+		logger.Error("Failed to read inputs of job %s: %v", req.JobID, err)
+		return "", errors.New("failed to claim job")
+	}
+
+	if err := job.transition(JobAssigned, "claimed by "+req.SellerID); err != nil {
+		return "", err
+	}
+	job.SellerID = req.SellerID
+	job.newOwners = append(job.newOwners, req.SellerID)
+	job.LeaseExpiresAt = time.Now().Unix() + JobLeaseDuration
+	job.Attempts++
+	job.Price = price
+
+	jobs, versions := []*Job{job}, []string{version}
+	if parent != nil {
+		if parent.ReplicaSellers == nil {
+			parent.ReplicaSellers = make(map[string]string)
+		}
+		parent.ReplicaSellers[req.JobID] = req.SellerID
+		jobs, versions = append(jobs, parent), append(versions, parentVersion)
+	}
+	if err := writeJobs(ctx, nk, jobs, versions); err == errJobConflict {
+		// Someone else changed the job between our read and write, most likely a competing claim
+		return "", errJobAlreadyClaimed
+	} else if err != nil {
+		logger.Error("Failed to store claim of job %s: %v", req.JobID, err)
+		return "", errors.New("failed to claim job")
+	}
+
+	logger.Info("Job %s claimed by seller %s", req.JobID, req.SellerID)
+	return marshalLease(job, inputs)
+}
+
+// RenewJobLease extends the lease of the seller running a job. The first
+// renewal also marks the job as running.
+func RenewJobLease(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req struct {
+		JobID    string `json:"job_id"`
+		SellerID string `json:"seller_id"`
+	}
+	if err := json.Unmarshal([]byte(payload), &req); err != nil {
+		return "", errors.New("invalid renew_job_lease request format")
+	}
+	if req.JobID == "" {
+		return "", errors.New("renew_job_lease requires a job_id")
+	}
+	sellerID, err := sessionUserID(ctx, req.SellerID)
+	if err != nil {
+		return "", err
+	}
+	req.SellerID = sellerID
+
+	job, version, err := readJob(ctx, nk, req.JobID)
+	if err == errJobNotFound {
+		return "", fmt.Errorf("%w: %v", errJobLeaseLost, err)
+	}
+	if err != nil {
+		logger.Error("Failed to read job %s: %v", req.JobID, err)
+		return "", errors.New("failed to renew lease")
+	}
+
+	// A finished or cancelled job has no lease left to renew
+	if job.State.Terminal() {
+		return "", fmt.Errorf("%w: job is %s", errJobLeaseLost, job.State)
+	}
+	if job.State == JobQueued || job.SellerID != req.SellerID {
+		return "", errJobLeaseLost
+	}
+
+	if job.State == JobAssigned {
+		if err := job.transition(JobRunning, "started by "+req.SellerID); err != nil {
+			return "", err
+		}
+	}
+	job.LeaseExpiresAt = time.Now().Unix() + JobLeaseDuration
+
+	if err := writeJob(ctx, nk, job, version); err == errJobConflict {
+		return "", err
+	} else if err != nil {
+		logger.Error("Failed to store lease of job %s: %v", req.JobID, err)
+		return "", errors.New("failed to renew lease")
+	}
+
+	return marshalLease(job, nil)
+}
+
+// runLeaseSweeper periodically requeues or expires jobs, and deletes the
+// logs of jobs finished long ago, until stop is closed
+func runLeaseSweeper(logger runtime.Logger, nk runtime.NakamaModule, stop <-chan struct{}) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			sweepJobs(context.Background(), logger, nk)
+			expireJobLogs(context.Background(), logger, nk)
+		}
+	}
+}
+
+// sweepJobs visits every job in the active index and handles expired leases
+// and stale queue entries. Finished jobs leave the index, so they are never rescanned.
+func sweepJobs(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule) {
+	cursor := ""
+	for {
+		page, next, err := listActiveJobs(ctx, nk, 100, cursor)
+		if err != nil {
+			logger.Error("Lease sweeper failed to list jobs: %v", err)
+			return
+		}
+		now := time.Now().Unix()
+		for _, j := range page {
+			if needsSweep(j, now) {
+				// Re-read with the current version so concurrent sweepers cannot both act on the job
+				if err := sweepJob(ctx, logger, nk, j.Request.JobID); err != nil && err != errJobConflict {
+					logger.Error("Lease sweeper failed on job %s: %v", j.Request.JobID, err)
+				}
+			}
+		}
+		if next == "" {
+			return
+		}
+		cursor = next
+	}
+}
+
+// needsSweep reports whether the sweeper has anything to do for the job
+func needsSweep(job *Job, now int64) bool {
+	// A verified job is concluded when its last replica finishes; the sweeper only catches a missed conclusion
+	if len(job.Replicas) > 0 {
+		return job.State == JobRunning && now-job.UpdatedAt >= reofferInterval
+	}
+	switch job.State {
+	case JobAssigned, JobRunning:
+		return job.LeaseExpiresAt < now
+	case JobQueued:
+		if now-job.QueuedAt > maxQueueSeconds {
+			return true
+		}
+		if job.Auction != nil {
+			return job.Auction.due(job.Request.Auction.Rule, job.OfferedAt, now)
+		}
+		return now-job.OfferedAt >= reofferInterval
+	}
+	// A finished replica stays unsettled until its verified job is concluded
+	unsettled := job.Billing != nil && job.Billing.SettledAt == 0
+	return job.ReplicaOf != "" && job.State.Terminal() && unsettled && now-job.UpdatedAt >= reofferInterval
+}
+
+// sweepJob requeues a job whose lease ran out, re-offers a job nobody claimed,
+// expires jobs that ran out of attempts or waited in the queue too long, and
+// finishes concluding verified jobs
+func sweepJob(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, jobID string) error {
+	job, version, err := readJob(ctx, nk, jobID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	if !needsSweep(job, now) {
+		return nil
+	}
+
+	if len(job.Replicas) > 0 {
+		return concludeVerification(ctx, logger, nk, job.Request.JobID)
+	}
+	if job.State.Terminal() {
+		return concludeVerification(ctx, logger, nk, job.ReplicaOf)
+	}
+	if job.State == JobAssigned || job.State == JobRunning {
+		return expireLease(ctx, logger, nk, job, version)
+	}
+	if now-job.QueuedAt > maxQueueSeconds {
+		return expireJob(ctx, logger, nk, job, version, "no seller claimed the job in time")
+	}
+	if job.Auction != nil {
+		return sweepAuction(ctx, logger, nk, job, version)
+	}
+	return reofferJob(ctx, nk, job, version, nil)
+}
+
+// expireLease takes a job back from a seller that stopped renewing its lease,
+// requeueing it for the other sellers or expiring it when it is out of
+// attempts. The lapse counts against the seller's reputation.
+func expireLease(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, job *Job, version string) error {
+	seller := job.SellerID
+	var err error
+	if job.Attempts >= MaxJobAttempts {
+		err = expireJob(ctx, logger, nk, job, version, fmt.Sprintf("lease expired on all %d attempts", job.Attempts))
+	} else {
+		logger.Warn("Lease of seller %s on job %s expired, requeueing", seller, job.Request.JobID)
+		if err := job.requeue("lease of seller " + seller + " expired"); err != nil {
+			return err
+		}
+		err = reofferJob(ctx, nk, job, version, []string{seller})
+	}
+	if err != nil {
+		return err
+	}
+	updateReputation(ctx, logger, nk, seller, func(r *Reputation) { r.LeaseExpiries++ })
+	return nil
+}
+
+// requeueInterrupted puts back in the queue a job its seller gave up on
+// while shutting down, and offers it to the other eligible sellers
+func requeueInterrupted(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, job *Job, version string) (string, error) {
+	seller := job.SellerID
+	if job.Attempts >= MaxJobAttempts {
+		if err := expireJob(ctx, logger, nk, job, version, fmt.Sprintf("interrupted on all %d attempts", job.Attempts)); err != nil {
+			logger.Error("Failed to expire job %s: %v", job.Request.JobID, err)
+			return "", errors.New("failed to record job result")
+		}
+		return "job_expired", nil
+	}
+
+	logger.Warn("Seller %s interrupted job %s, requeueing", seller, job.Request.JobID)
+	if err := job.requeue("interrupted by seller " + seller); err != nil {
+		return "", err
+	}
+	if err := reofferJob(ctx, nk, job, version, []string{seller}); err == errJobConflict {
+		return "", err
+	} else if err != nil {
+		logger.Error("Failed to requeue job %s: %v", job.Request.JobID, err)
+		return "", errors.New("failed to requeue job")
+	}
+	return "job_requeued", nil
+}
+
+// reofferJob stores a queued job and offers it to the eligible sellers with a
+// free slot, leaving out the excluded ones and those running its sibling replicas
+func reofferJob(ctx context.Context, nk runtime.NakamaModule, job *Job, version string, exclude []string) error {
+	// Sellers running another replica of the same verified job would not be independent
+	if job.ReplicaOf != "" {
+		taken, err := replicaSellers(ctx, nk, job)
+		if err != nil {
+			return err
+		}
+		exclude = append(exclude, taken...)
+	}
+	eligible, err := findEligibleSellers(ctx, nk, &job.Request, exclude)
+	if err != nil {
+		return err
+	}
+	// An auctioned job goes up for bidding again
+	if job.Request.Auction != nil {
+		return openAuction(ctx, nk, job, version, eligible)
+	}
+	// Sellers without a free slot get the job on a later sweep
+	eligible = withFreeSlots(withinEscrow(eligible, job))
+	job.markOffered(eligible)
+
+	if err := writeJob(ctx, nk, job, version); err != nil {
+		return err
+	}
+	if len(eligible) == 0 {
+		return nil
+	}
+	return offerJob(ctx, nk, &job.Request, eligible)
+}
+
+// expireJob moves a job to the terminal expired state and tells the buyer
+func expireJob(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, job *Job, version, reason string) error {
+	if err := job.transition(JobExpired, reason); err != nil {
+		return err
+	}
+
+	result := &JobResult{
+		JobID:     job.Request.JobID,
+		BuyerID:   job.Request.BuyerID,
+		SellerID:  job.SellerID,
+		Error:     "job expired: " + reason,
+		ExitCode:  -1,
+		Timestamp: time.Now().Unix(),
+		State:     JobExpired,
+	}
+	job.Result = result
+	job.LeaseExpiresAt = 0
+	job.settle(0, "", "job expired")
+
+	if err := writeJob(ctx, nk, job, version); err != nil {
+		return err
+	}
+
+	logger.Warn("Job %s expired: %s", job.Request.JobID, reason)
+	// The buyer hears about the verified job, not its replicas
+	if job.ReplicaOf != "" {
+		concludeReplica(ctx, logger, nk, job)
+		return nil
+	}
+	return notifyBuyer(ctx, nk, result, "Job Expired")
+}
+
+// agreePrice fixes the price of a job at the seller's current price card
+func agreePrice(job *Job, seller *SellerRecord) *AgreedPrice {
+	price := &AgreedPrice{SellerID: seller.UserID, AgreedAt: time.Now().Unix()}
+	if seller.Pricing != nil {
+		price.Card = *seller.Pricing
+	}
+	price.MaxCost = price.Card.MaxCost(job.Request.Resources)
+	if job.Auction != nil && job.Auction.Winner != nil {
+		bid := *job.Auction.Winner
+		price.Bid = &bid
+		price.MaxCost = bid.Price
+	}
+	return price
+}
+
+// marshalLease builds the response returned to the seller holding a job's
+// lease, with the job's inputs when given
+func marshalLease(job *Job, inputs *JobInputs) (string, error) {
+	req := job.Request
+	req.Inputs = inputs
+	out, err := json.Marshal(map[string]interface{}{
+		"job_id":           job.Request.JobID,
+		"state":            job.State,
+		"lease_expires_at": job.LeaseExpiresAt,
+		"job":              req,
+	})
+	if err != nil {
+		return "", errors.New("failed to encode lease")
+	}
+	return string(out), nil
+}
+
+// contains reports whether the list holds the value
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,318 @@
+package modules
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	// jobLogCollection holds the log chunks of every job, keyed by job, attempt and sequence number
+	jobLogCollection = "job_logs"
+	// jobLogMetaCollection records the last chunk and the bytes stored for each attempt of a job
+	jobLogMetaCollection = "job_log_meta"
+	// expiringLogCollection lists the logs of finished jobs, keyed by when they are deleted
+	expiringLogCollection = "job_logs_expiring"
+
+	// MaxLogChunkBytes caps the size of a single append_job_log call
+	MaxLogChunkBytes = 256 * 1024
+	// MaxLogBytes caps the log chunks stored for one attempt of a job
+	MaxLogBytes = 16 << 20
+	// LogLimitMessage is the error append_job_log returns once an attempt's log is full
+	LogLimitMessage = "job log limit reached"
+	// maxLogChunksPerRead caps how many chunks get_job_logs returns at once
+	maxLogChunksPerRead = 100
+	// logRetentionSeconds is how long the logs of a finished job are kept
+	logRetentionSeconds = 7 * 24 * 60 * 60
+)
+
+var errLogLimit = fmt.Errorf("%s: at most %d bytes per attempt", LogLimitMessage, MaxLogBytes)
+
+// LogLine is one line of job output
+type LogLine struct {
+	Stream string `json:"stream"` // "stdout" or "stderr"
+	Text   string `json:"text"`   // The line without its trailing newline
+}
+
+// LogChunk is a batch of log lines forwarded by the seller while a job runs
+type LogChunk struct {
+	Seq   int       `json:"seq"`   // Position of the chunk within the attempt, starting at 1
+	At    int64     `json:"at"`    // When the server received the chunk
+	Lines []LogLine `json:"lines"` // Lines in the order they were written
+}
+
+// logMeta tracks what is stored of one attempt's log
+type logMeta struct {
+	Seq   int   `json:"seq"`   // Last chunk stored
+	Bytes int64 `json:"bytes"` // Size of the chunks stored, as sent
+}
+
+// logExpiry schedules the deletion of a finished job's logs
+type logExpiry struct {
+	JobID     string `json:"job_id"`
+	Attempts  int    `json:"attempts"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// logChunkKey is the storage key of a log chunk
+func logChunkKey(jobID string, attempt, seq int) string {
+	return fmt.Sprintf("%s/%d/%08d", jobID, attempt, seq)
+}
+
+// logMetaKey is the storage key of an attempt's log metadata
+func logMetaKey(jobID string, attempt int) string {
+	return fmt.Sprintf("%s/%d", jobID, attempt)
+}
+
+// logExpiryKey sorts scheduled deletions by time, so the sweeper can stop at the first one not yet due
+func logExpiryKey(expiresAt int64, jobID string) string {
+	return fmt.Sprintf("%012d/%s", expiresAt, jobID)
+}
+
+// readLogMeta loads the log metadata of an attempt and its storage version
+func readLogMeta(ctx context.Context, nk runtime.NakamaModule, jobID string, attempt int) (*logMeta, string, error) {
+	objects, err := nk.StorageRead(ctx, []*runtime.StorageRead{{Collection: jobLogMetaCollection, Key: logMetaKey(jobID, attempt)}})
+	if err != nil {
+		return nil, "", err
+	}
+	if len(objects) == 0 {
+		return &logMeta{}, "*", nil
+	}
+	var meta logMeta
+	if err := json.Unmarshal([]byte(objects[0].Value), &meta); err != nil {
+		return nil, "", err
+	}
+	return &meta, objects[0].Version, nil
+}
+
+// AppendJobLog stores a chunk of output lines sent by the seller running a
+// job. Chunks must arrive in sequence; a chunk sent again after a lost
+// response is acknowledged without being stored twice. Each attempt may
+// store at most MaxLogBytes.
+func AppendJobLog(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if len(payload) > MaxLogChunkBytes {
+		return "", fmt.Errorf("log chunk exceeds %d bytes", MaxLogChunkBytes)
+	}
+
+	var req struct {
+		JobID string    `json:"job_id"`
+		Seq   int       `json:"seq"`
+		Lines []LogLine `json:"lines"`
+	}
+	if err := json.Unmarshal([]byte(payload), &req); err != nil {
+		return "", errors.New("invalid append_job_log request format")
+	}
+	if req.JobID == "" || req.Seq <= 0 {
+		return "", errors.New("append_job_log requires job_id and a positive seq")
+	}
+
+	userID := callerID(ctx)
+	if userID == "" {
+		return "", errNoSession
+	}
+
+	job, _, err := readJob(ctx, nk, req.JobID)
+	if err == errJobNotFound {
+		return "", err
+	}
+	if err != nil {
+		logger.Error("Failed to read job %s: %v", req.JobID, err)
+		return "", errors.New("failed to append job log")
+	}
+	if job.State.Terminal() {
+		return "", fmt.Errorf("job is %s", job.State)
+	}
+	if job.State == JobQueued || job.SellerID != userID {
+		return "", errJobLeaseLost
+	}
+
+	meta, version, err := readLogMeta(ctx, nk, req.JobID, job.Attempts)
+	if err != nil {
+		logger.Error("Failed to read log metadata of job %s: %v", req.JobID, err)
+		return "", errors.New("failed to append job log")
+	}
+	if req.Seq <= meta.Seq {
+		return `{"status":"ok"}`, nil
+	}
+	if req.Seq != meta.Seq+1 {
+		return "", fmt.Errorf("expected log chunk %d", meta.Seq+1)
+	}
+	if meta.Bytes+int64(len(payload)) > MaxLogBytes {
+		return "", errLogLimit
+	}
+	meta.Seq = req.Seq
+	meta.Bytes += int64(len(payload))
+
+	chunk := LogChunk{Seq: req.Seq, At: time.Now().Unix(), Lines: req.Lines}
+	value, err := json.Marshal(chunk)
+	if err != nil {
+		return "", errors.New("failed to encode log chunk")
+	}
+	metaValue, err := json.Marshal(meta)
+	if err != nil {
+		return "", errors.New("failed to encode log chunk")
+	}
+
+	// The chunk and the attempt's total are stored together
+	_, _, err = nk.MultiUpdate(ctx, nil, []*runtime.StorageWrite{{
+		Collection:      jobLogCollection,
+		Key:             logChunkKey(req.JobID, job.Attempts, req.Seq),
+		Value:           string(value),
+		PermissionRead:  0,
+		PermissionWrite: 0,
+	}, {
+		Collection:      jobLogMetaCollection,
+		Key:             logMetaKey(req.JobID, job.Attempts),
+		Value:           string(metaValue),
+		Version:         version,
+		PermissionRead:  0,
+		PermissionWrite: 0,
+	}}, nil, nil, false)
+	if errors.Is(err, runtime.ErrStorageRejectedVersion) {
+		return "", errors.New("log chunk was appended concurrently")
+	}
+	if err != nil {
+		logger.Error("Failed to store log chunk %d of job %s: %v", req.Seq, req.JobID, err)
+		return "", errors.New("failed to append job log")
+	}
+
+	return `{"status":"ok"}`, nil
+}
+
+// GetJobLogs returns the log chunks of a job after a given sequence number.
+// Without an attempt the latest attempt is read.
+func GetJobLogs(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req struct {
+		JobID    string `json:"job_id"`
+		Attempt  int    `json:"attempt"`
+		AfterSeq int    `json:"after_seq"`
+		Limit    int    `json:"limit"`
+	}
+	if err := json.Unmarshal([]byte(payload), &req); err != nil || req.JobID == "" {
+		return "", errors.New("get_job_logs requires a job_id")
+	}
+	if req.Limit <= 0 || req.Limit > maxLogChunksPerRead {
+		req.Limit = maxLogChunksPerRead
+	}
+
+	userID := callerID(ctx)
+	if userID == "" {
+		return "", errNoSession
+	}
+
+	job, _, err := readJob(ctx, nk, req.JobID)
+	if err == errJobNotFound {
+		return "", err
+	}
+	if err != nil {
+		logger.Error("Failed to read job %s: %v", req.JobID, err)
+		return "", errors.New("failed to read job logs")
+	}
+	if !job.visibleTo(userID) {
+		return "", errJobNotFound
+	}
+	if req.Attempt <= 0 {
+		req.Attempt = job.Attempts
+	}
+
+	// Chunks are numbered consecutively, so read the next keys in a single batch
+	reads := make([]*runtime.StorageRead, 0, req.Limit)
+	for seq := req.AfterSeq + 1; seq <= req.AfterSeq+req.Limit; seq++ {
+		reads = append(reads, &runtime.StorageRead{
+			Collection: jobLogCollection,
+			Key:        logChunkKey(req.JobID, req.Attempt, seq),
+		})
+	}
+	objects, err := nk.StorageRead(ctx, reads)
+	if err != nil {
+		logger.Error("Failed to read logs of job %s: %v", req.JobID, err)
+		return "", errors.New("failed to read job logs")
+	}
+
+	bySeq := make(map[int]LogChunk, len(objects))
+	for _, obj := range objects {
+		var chunk LogChunk
+		if err := json.Unmarshal([]byte(obj.Value), &chunk); err == nil {
+			bySeq[chunk.Seq] = chunk
+		}
+	}
+
+	// Stop at the first gap so a chunk still in flight is never skipped
+	chunks := []LogChunk{}
+	next := req.AfterSeq
+	for seq := req.AfterSeq + 1; seq <= req.AfterSeq+req.Limit; seq++ {
+		chunk, ok := bySeq[seq]
+		if !ok {
+			break
+		}
+		chunks = append(chunks, chunk)
+		next = seq
+	}
+
+	out, err := json.Marshal(map[string]interface{}{
+		"job_id":    job.Request.JobID,
+		"state":     job.State,
+		"attempt":   req.Attempt,
+		"attempts":  job.Attempts,
+		"chunks":    chunks,
+		"after_seq": next,
+	})
+	if err != nil {
+		return "", errors.New("failed to encode job logs")
+	}
+	return string(out), nil
+}
+
+// expireJobLogs deletes the logs of jobs that finished more than
+// logRetentionSeconds ago. Scheduled deletions are listed in order of time,
+// so only the due ones are read.
+func expireJobLogs(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule) {
+	now := time.Now().Unix()
+	cursor := ""
+	for {
+		objects, next, err := nk.StorageList(ctx, "", "", expiringLogCollection, 100, cursor)
+		if err != nil {
+			logger.Error("Failed to list expiring job logs: %v", err)
+			return
+		}
+		for _, obj := range objects {
+			var e logExpiry
+			if err := json.Unmarshal([]byte(obj.Value), &e); err != nil {
+				logger.Error("Invalid log expiry %s: %v", obj.Key, err)
+				continue
+			}
+			if e.ExpiresAt > now {
+				return
+			}
+			if err := deleteJobLogs(ctx, nk, &e, obj.Key); err != nil {
+				logger.Error("Failed to delete logs of job %s: %v", e.JobID, err)
+				return
+			}
+		}
+		if next == "" {
+			return
+		}
+		cursor = next
+	}
+}
+
+// deleteJobLogs deletes every log chunk and log metadata of a job, along with its scheduled deletion
+func deleteJobLogs(ctx context.Context, nk runtime.NakamaModule, e *logExpiry, key string) error {
+	deletes := []*runtime.StorageDelete{{Collection: expiringLogCollection, Key: key}}
+	for attempt := 1; attempt <= e.Attempts; attempt++ {
+		meta, _, err := readLogMeta(ctx, nk, e.JobID, attempt)
+		if err != nil {
+			return err
+		}
+		for seq := 1; seq <= meta.Seq; seq++ {
+			deletes = append(deletes, &runtime.StorageDelete{Collection: jobLogCollection, Key: logChunkKey(e.JobID, attempt, seq)})
+		}
+		deletes = append(deletes, &runtime.StorageDelete{Collection: jobLogMetaCollection, Key: logMetaKey(e.JobID, attempt)})
+	}
+	return nk.StorageDelete(ctx, deletes)
+}
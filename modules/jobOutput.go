@@ -0,0 +1,198 @@
+package modules
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	// jobOutputCollection holds output that did not fit inline in a JobResult
+	jobOutputCollection = "job_output"
+
+	// MaxInlineOutputBytes caps the stdout and stderr carried inline by a JobResult
+	MaxInlineOutputBytes = 64 * 1024
+	// MaxOutputChunkBytes caps the decoded size of one uploaded output chunk
+	MaxOutputChunkBytes = 128 * 1024
+	// MaxOutputBytes caps the overflow stored per stream of one attempt
+	MaxOutputBytes = 64 << 20
+
+	// maxOutputChunks is how many chunks a stream may be stored in
+	maxOutputChunks = MaxOutputBytes / MaxOutputChunkBytes
+)
+
+// outputStreams are the streams that may be stored outside the result
+var outputStreams = []string{"stdout", "stderr", "artifacts"}
+
+// outputChunk is a stored piece of overflow output
+type outputChunk struct {
+	Data string `json:"data"` // Base64 encoded bytes
+}
+
+// outputChunkKey is the storage key of an overflow chunk
+func outputChunkKey(jobID string, attempt int, stream string, index int) string {
+	return fmt.Sprintf("%s/%d/%s/%06d", jobID, attempt, stream, index)
+}
+
+// checkOutput rejects results whose inline output or overflow description is out of bounds
+func checkOutput(result *JobResult) error {
+	if len(result.Stdout) > MaxInlineOutputBytes || len(result.Stderr) > MaxInlineOutputBytes {
+		return fmt.Errorf("inline output exceeds %d bytes per stream", MaxInlineOutputBytes)
+	}
+	for stream, overflow := range result.Overflow {
+		if !contains(outputStreams, stream) {
+			return fmt.Errorf("unknown output stream %q", stream)
+		}
+		if overflow.Chunks < 0 || overflow.Chunks > maxOutputChunks {
+			return fmt.Errorf("overflow for %s must be at most %d chunks", stream, maxOutputChunks)
+		}
+		if overflow.Bytes < 0 || overflow.Bytes > int64(overflow.Chunks)*MaxOutputChunkBytes {
+			return fmt.Errorf("invalid overflow for %s", stream)
+		}
+	}
+	if (result.Artifacts != nil) != (result.Overflow["artifacts"].Chunks > 0) {
+		return errors.New("artifacts must be described and uploaded together")
+	}
+	return nil
+}
+
+// UploadJobOutput stores a chunk of overflow output sent by the seller running a job
+func UploadJobOutput(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req struct {
+		JobID  string `json:"job_id"`
+		Stream string `json:"stream"`
+		Index  int    `json:"index"`
+		Data   string `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(payload), &req); err != nil {
+		return "", errors.New("invalid upload_job_output request format")
+	}
+	if req.JobID == "" || req.Index < 0 || !contains(outputStreams, req.Stream) {
+		return "", errors.New("upload_job_output requires job_id, a known stream and a non-negative index")
+	}
+	if req.Index >= maxOutputChunks {
+		return "", fmt.Errorf("output is limited to %d chunks per stream", maxOutputChunks)
+	}
+	data, err := base64.StdEncoding.DecodeString(req.Data)
+	if err != nil {
+		return "", errors.New("output chunk data must be base64 encoded")
+	}
+	if len(data) > MaxOutputChunkBytes {
+		return "", fmt.Errorf("output chunk exceeds %d bytes", MaxOutputChunkBytes)
+	}
+
+	userID := callerID(ctx)
+	if userID == "" {
+		return "", errNoSession
+	}
+
+	job, _, err := readJob(ctx, nk, req.JobID)
+	if err == errJobNotFound {
+		return "", err
+	}
+	if err != nil {
+		logger.Error("Failed to read job %s: %v", req.JobID, err)
+		return "", errors.New("failed to store job output")
+	}
+	if job.State.Terminal() {
+		return "", fmt.Errorf("job is %s", job.State)
+	}
+	if job.State == JobQueued || job.SellerID != userID {
+		return "", errJobLeaseLost
+	}
+
+	value, err := json.Marshal(outputChunk{Data: req.Data})
+	if err != nil {
+		return "", errors.New("failed to encode output chunk")
+	}
+	if _, err := nk.StorageWrite(ctx, []*runtime.StorageWrite{{
+		Collection:      jobOutputCollection,
+		Key:             outputChunkKey(req.JobID, job.Attempts, req.Stream, req.Index),
+		Value:           string(value),
+		PermissionRead:  0,
+		PermissionWrite: 0,
+	}}); err != nil {
+		logger.Error("Failed to store %s chunk %d of job %s: %v", req.Stream, req.Index, req.JobID, err)
+		return "", errors.New("failed to store job output")
+	}
+
+	return `{"status":"ok"}`, nil
+}
+
+// GetJobOutput returns one overflow chunk of a finished job's output
+func GetJobOutput(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req struct {
+		JobID  string `json:"job_id"`
+		Stream string `json:"stream"`
+		Index  int    `json:"index"`
+	}
+	if err := json.Unmarshal([]byte(payload), &req); err != nil || req.JobID == "" || req.Stream == "" {
+		return "", errors.New("get_job_output requires a job_id and a stream")
+	}
+
+	userID := callerID(ctx)
+	if userID == "" {
+		return "", errNoSession
+	}
+
+	job, _, err := readJob(ctx, nk, req.JobID)
+	if err == errJobNotFound {
+		return "", err
+	}
+	if err != nil {
+		logger.Error("Failed to read job %s: %v", req.JobID, err)
+		return "", errors.New("failed to read job output")
+	}
+	if !job.visibleTo(userID) {
+		return "", errJobNotFound
+	}
+	if job.Result == nil {
+		return "", errors.New("job has no result yet")
+	}
+	overflow, ok := job.Result.Overflow[req.Stream]
+	if !ok || req.Index < 0 || req.Index >= overflow.Chunks {
+		return "", errors.New("no such output chunk")
+	}
+
+	// A verified job's output was uploaded by the replica whose result was delivered
+	if c := job.Result.Consensus; c != nil && c.DeliveredFrom != "" {
+		if job, _, err = readJob(ctx, nk, c.DeliveredFrom); err != nil {
+			logger.Error("Failed to read replica %s: %v", c.DeliveredFrom, err)
+			return "", errors.New("failed to read job output")
+		}
+	}
+
+	objects, err := nk.StorageRead(ctx, []*runtime.StorageRead{{
+		Collection: jobOutputCollection,
+		Key:        outputChunkKey(job.Request.JobID, job.Attempts, req.Stream, req.Index),
+	}})
+	if err != nil {
+		logger.Error("Failed to read %s chunk %d of job %s: %v", req.Stream, req.Index, req.JobID, err)
+		return "", errors.New("failed to read job output")
+	}
+	if len(objects) == 0 {
+		return "", errors.New("output chunk is missing from storage")
+	}
+
+	var chunk outputChunk
+	if err := json.Unmarshal([]byte(objects[0].Value), &chunk); err != nil {
+		return "", errors.New("failed to decode output chunk")
+	}
+
+	out, err := json.Marshal(map[string]interface{}{
+		"job_id": req.JobID,
+		"stream": req.Stream,
+		"index":  req.Index,
+		"chunks": overflow.Chunks,
+		"data":   chunk.Data,
+	})
+	if err != nil {
+		return "", errors.New("failed to encode job output")
+	}
+	return string(out), nil
+}
@@ -1,11 +1,59 @@
 package modules
 
+import (
+	"encoding/json"
+	"regexp"
+)
+
+// Notification codes used for marketplace messages
+const (
+	NotificationJobResult  = 1 // A JobResult delivered to the buyer
+	NotificationJobOffer   = 2 // A JobRequest offered to an eligible seller
+	NotificationJobCancel  = 3 // Tells the assigned seller to stop a cancelled job
+	NotificationJobAuction = 4 // Invites an eligible seller to bid on a job
+)
+
+// maxJobIDLength caps the length of a job ID chosen by the buyer
+const maxJobIDLength = 64
+
+// jobIDPattern is what a job ID chosen by the buyer must look like. Sellers
+// name containers after job IDs, so it stays within Docker's name syntax.
+var jobIDPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// validJobID reports whether a job ID chosen by the buyer is acceptable
+func validJobID(id string) bool {
+	return len(id) <= maxJobIDLength && jobIDPattern.MatchString(id)
+}
+
+// NotificationContent is the body of every marketplace notification
+type NotificationContent struct {
+	Type string          `json:"type"` // Kind of message, e.g. "job_request" or "job_result"
+	Data json.RawMessage `json:"data"` // Payload whose shape depends on Type
+}
+
 // JobRequest represents a compute job to be executed
 type JobRequest struct {
 	Image   string `json:"image"`    // Docker image to use
 	Command string `json:"command"`  // Command to run inside the container
 	BuyerID string `json:"buyer_id"` // ID of the buyer requesting the job
 	JobID   string `json:"job_id"`   // Unique identifier for the job
+
+	// Labels the seller must advertise to be offered the job, e.g. "arch=amd64" or "gpu"
+	Requirements []string `json:"requirements,omitempty"`
+	// Compute the job needs; unset fields take DefaultResources
+	Resources Resources `json:"resources"`
+	// Files mounted read-only at /in
+	Inputs *JobInputs `json:"inputs,omitempty"`
+	// Most the buyer pays for the job, in credits; only sellers whose price
+	// card keeps the job's maximum cost within it are offered the job. Zero means no ceiling.
+	MaxPrice int64 `json:"max_price,omitempty"`
+	// Places the job by auction among the eligible sellers; requires MaxPrice
+	Auction *AuctionOptions `json:"auction,omitempty"`
+	// Lowest reputation score, between 0 and 1, a seller needs to be offered
+	// the job. Zero accepts every seller; new sellers score NeutralReputation.
+	MinReputation float64 `json:"min_reputation,omitempty"`
+	// Runs the job on several independent sellers and delivers the result most of them agree on
+	Verification *VerificationOptions `json:"verification,omitempty"`
 }
 
 // JobResult represents the result of a compute job
@@ -13,8 +61,34 @@ type JobResult struct {
 	JobID     string `json:"job_id"`    // ID of the job that was executed
 	BuyerID   string `json:"buyer_id"`  // ID of the buyer who requested the job
 	SellerID  string `json:"seller_id"` // ID of the seller who executed the job
-	Output    string `json:"output"`    // Output from the command execution
+	Stdout    string `json:"stdout"`    // Standard output, up to the seller's inline cap
+	Stderr    string `json:"stderr"`    // Standard error, up to the seller's inline cap
 	Error     string `json:"error"`     // Error message if job failed
 	ExitCode  int    `json:"exit_code"` // Exit code from the container
 	Timestamp int64  `json:"timestamp"` // When the job was completed
+
+	// Output that did not fit inline, keyed by stream ("stdout", "stderr" or
+	// "artifacts"). It is kept in storage and read back with get_job_output.
+	Overflow map[string]OutputOverflow `json:"overflow,omitempty"`
+	// Set when the job wrote more than the seller keeps and the rest was dropped
+	OutputTruncated bool `json:"output_truncated,omitempty"`
+	// Files the job wrote to /out, if any
+	Artifacts *Artifacts `json:"artifacts,omitempty"`
+	// Hardening the seller ran the job's container with
+	Sandbox *SandboxProfile `json:"sandbox,omitempty"`
+	// Resources the job consumed, measured by the seller
+	Usage *Usage `json:"usage,omitempty"`
+	// How the replicas of a verified job compared
+	Consensus *ConsensusReport `json:"consensus,omitempty"`
+	// Set by a seller that shut down before the job finished; the job is requeued
+	Interrupted bool `json:"interrupted,omitempty"`
+
+	// Final state of the job, filled in by the server when the result is delivered
+	State JobState `json:"state,omitempty"`
+}
+
+// OutputOverflow describes the part of an output stream stored outside the result
+type OutputOverflow struct {
+	Bytes  int64 `json:"bytes"`  // Size of the stored part
+	Chunks int   `json:"chunks"` // Number of chunks it was uploaded in
 }
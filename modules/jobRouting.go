@@ -0,0 +1,132 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"path"
+	"time"
+
+	"github.com/bdr-pro/lumaris/imageref"
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+var errNoEligibleSeller = errors.New("no eligible seller for this job")
+
+// eligibleSellers returns the online sellers able to run the job within their advertised limits
+func eligibleSellers(sellers []*SellerRecord, job *JobRequest, now int64) []*SellerRecord {
+	var eligible []*SellerRecord
+	for _, s := range sellers {
+		if !s.Online(now) || s.Draining {
+			continue
+		}
+		if !s.AcceptsImage(job.Image) {
+			continue
+		}
+		if !hasLabels(s.Labels, job.Requirements) {
+			continue
+		}
+		if len(job.Resources.Exceeds(s.Limits)) > 0 {
+			continue
+		}
+		// Auctioned jobs are priced by the bids, not the price cards
+		if job.MaxPrice > 0 && job.Auction == nil && s.Pricing.MaxCost(job.Resources) > job.MaxPrice {
+			continue
+		}
+		eligible = append(eligible, s)
+	}
+	return eligible
+}
+
+// findEligibleSellers returns the online sellers able to run the job with the
+// reputation it asks for, leaving out the excluded ones
+func findEligibleSellers(ctx context.Context, nk runtime.NakamaModule, job *JobRequest, exclude []string) ([]*SellerRecord, error) {
+	sellers, err := listSellers(ctx, nk)
+	if err != nil {
+		return nil, err
+	}
+
+	var eligible []*SellerRecord
+	for _, s := range eligibleSellers(sellers, job, time.Now().Unix()) {
+		if !contains(exclude, s.UserID) {
+			eligible = append(eligible, s)
+		}
+	}
+	return withReputation(ctx, nk, eligible, job.MinReputation)
+}
+
+// withFreeSlots keeps the sellers that can take another job right now
+func withFreeSlots(sellers []*SellerRecord) []*SellerRecord {
+	var free []*SellerRecord
+	for _, s := range sellers {
+		if s.HasFreeSlot() {
+			free = append(free, s)
+		}
+	}
+	return free
+}
+
+// withinEscrow keeps the sellers whose price the job's escrow covers, so a
+// seller that raised its prices or registered after the job was submitted
+// is not offered it
+func withinEscrow(sellers []*SellerRecord, job *Job) []*SellerRecord {
+	if job.Billing == nil || job.Auction != nil {
+		return sellers
+	}
+	var covered []*SellerRecord
+	for _, s := range sellers {
+		if s.Pricing.MaxCost(job.Request.Resources) <= job.Billing.Escrow {
+			covered = append(covered, s)
+		}
+	}
+	return covered
+}
+
+// canRunImage reports whether any capability pattern matches the image.
+// Patterns use path.Match syntax, so "python:*" matches every python tag,
+// and are also compared to the normalized image so that
+// "docker.io/library/python:3.*" matches "python:3.10".
+func canRunImage(capabilities []string, image string) bool {
+	for _, pattern := range capabilities {
+		if pattern == image {
+			return true
+		}
+		if ok, err := path.Match(pattern, image); err == nil && ok {
+			return true
+		}
+		if imageref.Match(pattern, image) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasLabels reports whether every required label is advertised
+func hasLabels(labels, required []string) bool {
+	for _, req := range required {
+		if !contains(labels, req) {
+			return false
+		}
+	}
+	return true
+}
+
+// offerJob sends the job to each of the given sellers as a job_request notification
+func offerJob(ctx context.Context, nk runtime.NakamaModule, job *JobRequest, sellers []*SellerRecord) error {
+	content := map[string]interface{}{
+		"type": "job_request",
+		"data": job,
+	}
+
+	notifications := make([]*runtime.NotificationSend, 0, len(sellers))
+	for _, s := range sellers {
+		notifications = append(notifications, &runtime.NotificationSend{
+			UserID:     s.UserID,
+			Subject:    "Job Offer",
+			Content:    content,
+			Code:       NotificationJobOffer,
+			Persistent: false,
+		})
+	}
+
+	return nk.NotificationsSend(ctx, notifications)
+}
@@ -0,0 +1,45 @@
+package modules
+
+import "testing"
+
+func TestEligibleSellersFollowImagePolicy(t *testing.T) {
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	sellers := []*SellerRecord{
+		{UserID: "open", Capabilities: []string{"python:*"}},
+		{UserID: "deny-old", Capabilities: []string{"python:*"}, DeniedImages: []string{"python:3.6*"}},
+		{UserID: "pinned", Capabilities: []string{"*"}, RequireDigest: true},
+		{UserID: "node", Capabilities: []string{"node:16"}},
+	}
+	for _, s := range sellers {
+		s.LastHeartbeat, s.TTL = 100, DefaultSellerTTL
+	}
+
+	tests := []struct {
+		image string
+		want  []string
+	}{
+		{"python:3.10", []string{"open", "deny-old"}},
+		{"python:3.6.15", []string{"open"}},
+		{"docker.io/library/python:3.6", []string{"open"}},
+		{"python:3.10@" + digest, []string{"open", "deny-old", "pinned"}},
+		{"node:16", []string{"node"}},
+		{"ruby:3", nil},
+	}
+	for _, tt := range tests {
+		job := &JobRequest{Image: tt.image}
+		var got []string
+		for _, s := range eligibleSellers(sellers, job, 100) {
+			got = append(got, s.UserID)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got sellers %v, want %v", tt.image, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got sellers %v, want %v", tt.image, got, tt.want)
+				break
+			}
+		}
+	}
+}
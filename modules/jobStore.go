@@ -0,0 +1,516 @@
+package modules
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	// jobCollection holds one system-owned storage object per job, keyed by job ID
+	jobCollection = "jobs"
+	// jobIndexCollection holds an empty object per job for every user who
+	// bought or held it, owned by that user and keyed by job ID, so a user's
+	// jobs are listed without scanning every job
+	jobIndexCollection = "job_index"
+	// activeJobCollection holds an empty system-owned object per job the
+	// sweeper still has work to do on, keyed by job ID
+	activeJobCollection = "jobs_active"
+)
+
+// JobConflictMessage is the error of an RPC that lost a race with another
+// update of the same job; the call is safe to retry
+const JobConflictMessage = "job was changed concurrently"
+
+var (
+	errJobNotFound = errors.New("job not found")
+	errJobExists   = errors.New("a job with this job_id already exists")
+	errJobConflict = errors.New(JobConflictMessage)
+)
+
+// JobState is a step in the lifecycle of a job
+type JobState string
+
+// Job lifecycle states
+const (
+	JobQueued    JobState = "queued"    // Waiting for a seller
+	JobAssigned  JobState = "assigned"  // A seller has taken the job
+	JobRunning   JobState = "running"   // The seller is executing the job
+	JobSucceeded JobState = "succeeded" // Finished with exit code 0
+	JobFailed    JobState = "failed"    // Finished with an error or non-zero exit code
+	JobCancelled JobState = "cancelled" // Stopped at the buyer's request
+	JobExpired   JobState = "expired"   // Given up on by the server
+)
+
+// jobTransitions lists the states each state may move to
+var jobTransitions = map[JobState][]JobState{
+	JobQueued:   {JobAssigned, JobCancelled, JobExpired},
+	JobAssigned: {JobRunning, JobQueued, JobSucceeded, JobFailed, JobCancelled, JobExpired},
+	JobRunning:  {JobQueued, JobSucceeded, JobFailed, JobCancelled, JobExpired},
+}
+
+// Terminal reports whether no further transitions are possible from the state
+func (s JobState) Terminal() bool {
+	return len(jobTransitions[s]) == 0
+}
+
+// CanTransition reports whether a job may move from s to the given state
+func (s JobState) CanTransition(to JobState) bool {
+	for _, next := range jobTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// JobTransition records a state change in a job's history
+type JobTransition struct {
+	State  JobState `json:"state"`
+	At     int64    `json:"at"`
+	Reason string   `json:"reason,omitempty"`
+}
+
+// Job is the server-side record of a job request and its progress
+type Job struct {
+	Request        JobRequest        `json:"request"`                    // The job as submitted by the buyer
+	State          JobState          `json:"state"`                      // Current lifecycle state
+	SellerID       string            `json:"seller_id,omitempty"`        // Seller holding the lease on the job
+	LeaseExpiresAt int64             `json:"lease_expires_at,omitempty"` // When the seller's lease runs out
+	OfferedTo      []string          `json:"offered_to,omitempty"`       // Sellers the job was last offered to
+	Attempts       int               `json:"attempts"`                   // How many times the job has been claimed
+	QueuedAt       int64             `json:"queued_at"`                  // When the job last entered the queue
+	OfferedAt      int64             `json:"offered_at,omitempty"`       // When the job was last offered to sellers
+	Result         *JobResult        `json:"result,omitempty"`           // Final result once the job is finished
+	Usage          *Usage            `json:"usage,omitempty"`            // Resources the job consumed, as reported by its seller
+	Billing        *Billing          `json:"billing,omitempty"`          // Credits held in escrow and how they were settled
+	Price          *AgreedPrice      `json:"price,omitempty"`            // Price agreed with the seller holding the job
+	Auction        *Auction          `json:"auction,omitempty"`          // Bidding state, for jobs placed by auction
+	Dispute        *Dispute          `json:"dispute,omitempty"`          // The buyer's dispute of the result, if any
+	Replicas       []string          `json:"replicas,omitempty"`         // Jobs running a verified job on separate sellers
+	ReplicaOf      string            `json:"replica_of,omitempty"`       // Verified job this job is a replica of
+	ReplicaSellers map[string]string `json:"replica_sellers,omitempty"`  // Seller that claimed each replica, by replica ID
+	CreatedAt      int64             `json:"created_at"`                 // When the job was submitted
+	UpdatedAt      int64             `json:"updated_at"`                 // When the job last changed
+	History        []JobTransition   `json:"history"`                    // Every state the job has been in
+
+	// Bookkeeping for the job's next write, not stored with the job
+	inActiveIndex bool                    // Whether the active index holds the job
+	newOwners     []string                // Users whose index the job joins on its next write
+	inputs        *JobInputs              // Inputs stored alongside the job on its next write
+	walletUpdates []*runtime.WalletUpdate // Credits moved in the same transaction as the next write
+}
+
+// newJob creates the record for a freshly submitted job
+func newJob(req JobRequest) *Job {
+	now := time.Now().Unix()
+	return &Job{
+		Request:   req,
+		State:     JobQueued,
+		QueuedAt:  now,
+		CreatedAt: now,
+		UpdatedAt: now,
+		History:   []JobTransition{{State: JobQueued, At: now}},
+		newOwners: []string{req.BuyerID},
+	}
+}
+
+// active reports whether the sweeper may still have work to do on the job:
+// it is unfinished, or it is a finished replica that has not been settled
+func (j *Job) active() bool {
+	if !j.State.Terminal() {
+		return true
+	}
+	return j.ReplicaOf != "" && j.Billing != nil && j.Billing.SettledAt == 0
+}
+
+// transition moves the job to a new state if the lifecycle allows it
+func (j *Job) transition(to JobState, reason string) error {
+	if !j.State.CanTransition(to) {
+		return fmt.Errorf("job %s cannot move from %s to %s", j.Request.JobID, j.State, to)
+	}
+	now := time.Now().Unix()
+	j.State = to
+	j.UpdatedAt = now
+	j.History = append(j.History, JobTransition{State: to, At: now, Reason: reason})
+	return nil
+}
+
+// requeue takes the job back from its seller and puts it in the queue again
+func (j *Job) requeue(reason string) error {
+	if err := j.transition(JobQueued, reason); err != nil {
+		return err
+	}
+	j.SellerID = ""
+	j.LeaseExpiresAt = 0
+	j.QueuedAt = time.Now().Unix()
+	return nil
+}
+
+// markOffered records the sellers a job has just been offered to
+func (j *Job) markOffered(sellers []*SellerRecord) {
+	j.OfferedTo = j.OfferedTo[:0]
+	for _, s := range sellers {
+		j.OfferedTo = append(j.OfferedTo, s.UserID)
+	}
+	j.OfferedAt = time.Now().Unix()
+}
+
+// visibleTo reports whether the user may see everything about the job: its
+// buyer, and the seller that holds its lease or delivered its result
+func (j *Job) visibleTo(userID string) bool {
+	if j.Request.BuyerID == userID || j.SellerID == userID {
+		return true
+	}
+	return j.Result != nil && j.Result.SellerID == userID
+}
+
+// offerFor returns what a seller the job is offered to may see of it, the
+// request and its state, or nil once the job has left the queue
+func (j *Job) offerFor(userID string) *Job {
+	if j.State != JobQueued || !contains(j.OfferedTo, userID) {
+		return nil
+	}
+	return &Job{Request: j.Request, State: j.State}
+}
+
+// GetJobStatus returns the stored record of a job
+func GetJobStatus(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req struct {
+		JobID string `json:"job_id"`
+	}
+	if err := json.Unmarshal([]byte(payload), &req); err != nil || req.JobID == "" {
+		return "", errors.New("get_job_status requires a job_id")
+	}
+
+	userID := callerID(ctx)
+	if userID == "" {
+		return "", errNoSession
+	}
+
+	job, _, err := readJob(ctx, nk, req.JobID)
+	if err == errJobNotFound {
+		return "", err
+	}
+	if err != nil {
+		logger.Error("Failed to read job %s: %v", req.JobID, err)
+		return "", errors.New("failed to read job")
+	}
+	// Only the buyer and the leaseholder see the whole job; a seller it is
+	// offered to sees the request while the job is queued
+	view := job.offerFor(userID)
+	if job.visibleTo(userID) {
+		view = job.sealedFor(userID)
+	}
+	if view == nil {
+		return "", errJobNotFound
+	}
+
+	out, err := json.Marshal(view)
+	if err != nil {
+		return "", errors.New("failed to encode job")
+	}
+	return string(out), nil
+}
+
+// ListJobs returns the caller's jobs, optionally filtered by role ("buyer" or "seller") and state
+func ListJobs(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req struct {
+		Role   string   `json:"role"`
+		State  JobState `json:"state"`
+		Limit  int      `json:"limit"`
+		Cursor string   `json:"cursor"`
+	}
+	if payload != "" {
+		if err := json.Unmarshal([]byte(payload), &req); err != nil {
+			return "", errors.New("invalid list_jobs request format")
+		}
+	}
+
+	userID := callerID(ctx)
+	if userID == "" {
+		return "", errNoSession
+	}
+	if req.Role != "" && req.Role != "buyer" && req.Role != "seller" {
+		return "", errors.New("role must be buyer or seller")
+	}
+	if req.Limit <= 0 || req.Limit > 100 {
+		req.Limit = 100
+	}
+
+	match := func(j *Job) bool {
+		if req.State != "" && j.State != req.State {
+			return false
+		}
+		isBuyer := j.Request.BuyerID == userID
+		isSeller := j.SellerID == userID
+		switch req.Role {
+		case "buyer":
+			return isBuyer
+		case "seller":
+			return isSeller
+		}
+		return isBuyer || isSeller
+	}
+
+	// Only the caller's own index is read, a page of it at a time. Each page
+	// asks for no more entries than are still missing, so the list never
+	// exceeds the limit and the cursor resumes right after the last entry read.
+	jobs := []*Job{}
+	cursor := req.Cursor
+	for len(jobs) < req.Limit {
+		objects, next, err := nk.StorageList(ctx, "", userID, jobIndexCollection, req.Limit-len(jobs), cursor)
+		if err != nil {
+			logger.Error("Failed to list jobs of user %s: %v", userID, err)
+			return "", errors.New("failed to list jobs")
+		}
+		ids := make([]string, 0, len(objects))
+		for _, obj := range objects {
+			ids = append(ids, obj.Key)
+		}
+		page, err := readJobs(ctx, nk, ids)
+		if err != nil {
+			logger.Error("Failed to read jobs of user %s: %v", userID, err)
+			return "", errors.New("failed to list jobs")
+		}
+		for _, j := range page {
+			if match(j) {
+				jobs = append(jobs, j.sealedFor(userID))
+			}
+		}
+		cursor = next
+		if cursor == "" {
+			break
+		}
+	}
+
+	out, err := json.Marshal(map[string]interface{}{"jobs": jobs, "cursor": cursor})
+	if err != nil {
+		return "", errors.New("failed to encode job list")
+	}
+	return string(out), nil
+}
+
+// readJob loads a job record and its storage version
+func readJob(ctx context.Context, nk runtime.NakamaModule, jobID string) (*Job, string, error) {
+	objects, err := nk.StorageRead(ctx, []*runtime.StorageRead{{
+		Collection: jobCollection,
+		Key:        jobID,
+	}})
+	if err != nil {
+		return nil, "", err
+	}
+	if len(objects) == 0 {
+		return nil, "", errJobNotFound
+	}
+
+	job, err := decodeJob(objects[0].Value)
+	if err != nil {
+		return nil, "", err
+	}
+	return job, objects[0].Version, nil
+}
+
+// readJobs loads the job records with the given IDs in a single read, in
+// the order given; IDs without a record are left out
+func readJobs(ctx context.Context, nk runtime.NakamaModule, jobIDs []string) ([]*Job, error) {
+	if len(jobIDs) == 0 {
+		return nil, nil
+	}
+	reads := make([]*runtime.StorageRead, 0, len(jobIDs))
+	for _, id := range jobIDs {
+		reads = append(reads, &runtime.StorageRead{Collection: jobCollection, Key: id})
+	}
+	objects, err := nk.StorageRead(ctx, reads)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]*Job, len(objects))
+	for _, obj := range objects {
+		job, err := decodeJob(obj.Value)
+		if err != nil {
+			continue
+		}
+		byID[obj.Key] = job
+	}
+	jobs := make([]*Job, 0, len(byID))
+	for _, id := range jobIDs {
+		if job, ok := byID[id]; ok {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+// decodeJob parses a stored job record
+func decodeJob(value string) (*Job, error) {
+	var job Job
+	if err := json.Unmarshal([]byte(value), &job); err != nil {
+		return nil, err
+	}
+	job.inActiveIndex = job.active()
+	return &job, nil
+}
+
+// createJob stores a new job record, failing if the job ID is already taken
+func createJob(ctx context.Context, nk runtime.NakamaModule, job *Job) error {
+	return createJobs(ctx, nk, []*Job{job})
+}
+
+// createJobs stores several new job records in a single transaction, failing if any job ID is taken
+func createJobs(ctx context.Context, nk runtime.NakamaModule, jobs []*Job) error {
+	versions := make([]string, len(jobs))
+	for i := range versions {
+		versions[i] = "*"
+	}
+	err := storeJobs(ctx, nk, jobs, versions)
+	if errors.Is(err, runtime.ErrStorageRejectedVersion) {
+		return errJobExists
+	}
+	return err
+}
+
+// writeJob updates a job record only if it still has the version it was read at, so
+// concurrent updates (such as two sellers claiming the same job) cannot overwrite each other
+func writeJob(ctx context.Context, nk runtime.NakamaModule, job *Job, version string) error {
+	return writeJobs(ctx, nk, []*Job{job}, []string{version})
+}
+
+// writeJobs updates several job records in a single transaction, only if every
+// one of them still has the version it was read at
+func writeJobs(ctx context.Context, nk runtime.NakamaModule, jobs []*Job, versions []string) error {
+	err := storeJobs(ctx, nk, jobs, versions)
+	if errors.Is(err, runtime.ErrStorageRejectedVersion) {
+		return errJobConflict
+	}
+	return err
+}
+
+// storeJob persists a job record with the given storage version condition
+func storeJob(ctx context.Context, nk runtime.NakamaModule, job *Job, version string) error {
+	return storeJobs(ctx, nk, []*Job{job}, []string{version})
+}
+
+// storeJobs persists job records, each with its storage version condition,
+// together with the index and wallet changes they need, in a single transaction
+func storeJobs(ctx context.Context, nk runtime.NakamaModule, jobs []*Job, versions []string) error {
+	var (
+		writes  []*runtime.StorageWrite
+		deletes []*runtime.StorageDelete
+		wallets []*runtime.WalletUpdate
+	)
+	for i, job := range jobs {
+		wallets = append(wallets, job.walletUpdates...)
+		value, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		writes = append(writes, &runtime.StorageWrite{
+			Collection:      jobCollection,
+			Key:             job.Request.JobID,
+			Value:           string(value),
+			Version:         versions[i],
+			PermissionRead:  0,
+			PermissionWrite: 0,
+		})
+
+		if !job.inputs.Empty() {
+			value, err := json.Marshal(job.inputs)
+			if err != nil {
+				return err
+			}
+			writes = append(writes, &runtime.StorageWrite{
+				Collection:      jobInputsCollection,
+				Key:             job.Request.JobID,
+				Value:           string(value),
+				PermissionRead:  0,
+				PermissionWrite: 0,
+			})
+		}
+		for _, owner := range job.newOwners {
+			writes = append(writes, &runtime.StorageWrite{
+				Collection:      jobIndexCollection,
+				Key:             job.Request.JobID,
+				UserID:          owner,
+				Value:           "{}",
+				PermissionRead:  0,
+				PermissionWrite: 0,
+			})
+		}
+		switch active := job.active(); {
+		case active && !job.inActiveIndex:
+			writes = append(writes, &runtime.StorageWrite{
+				Collection:      activeJobCollection,
+				Key:             job.Request.JobID,
+				Value:           "{}",
+				PermissionRead:  0,
+				PermissionWrite: 0,
+			})
+		case !active && job.inActiveIndex:
+			deletes = append(deletes, &runtime.StorageDelete{
+				Collection: activeJobCollection,
+				Key:        job.Request.JobID,
+			})
+			// Logs are kept a while longer for the buyer to read
+			expiry := logExpiry{JobID: job.Request.JobID, Attempts: job.Attempts, ExpiresAt: time.Now().Unix() + logRetentionSeconds}
+			value, err := json.Marshal(expiry)
+			if err != nil {
+				return err
+			}
+			writes = append(writes, &runtime.StorageWrite{
+				Collection:      expiringLogCollection,
+				Key:             logExpiryKey(expiry.ExpiresAt, expiry.JobID),
+				Value:           string(value),
+				PermissionRead:  0,
+				PermissionWrite: 0,
+			})
+			// Replicas share the inputs of their verified job, which outlives them
+			if job.ReplicaOf == "" {
+				deletes = append(deletes, &runtime.StorageDelete{
+					Collection: jobInputsCollection,
+					Key:        job.Request.JobID,
+				})
+			}
+		}
+	}
+
+	_, _, err := nk.MultiUpdate(ctx, nil, writes, deletes, wallets, true)
+	var negative *runtime.WalletNegativeError
+	if errors.As(err, &negative) {
+		return errInsufficientCredits
+	}
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		job.inActiveIndex = job.active()
+		job.newOwners = nil
+		job.inputs = nil
+		job.walletUpdates = nil
+	}
+	return nil
+}
+
+// listActiveJobs loads one page of the jobs the sweeper may still have work to do on
+func listActiveJobs(ctx context.Context, nk runtime.NakamaModule, limit int, cursor string) ([]*Job, string, error) {
+	objects, next, err := nk.StorageList(ctx, "", "", activeJobCollection, limit, cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ids := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		ids = append(ids, obj.Key)
+	}
+	jobs, err := readJobs(ctx, nk, ids)
+	if err != nil {
+		return nil, "", err
+	}
+	return jobs, next, nil
+}
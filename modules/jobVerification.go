@@ -0,0 +1,506 @@
+package modules
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"sort"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	// defaultReplicas is how many sellers run a verified job when the buyer does not say
+	defaultReplicas = 3
+	// maxReplicas caps how many sellers a buyer may ask to run a verified job
+	maxReplicas = 5
+	// outputChunksPerRead is how many overflow chunks are read at once while hashing output
+	outputChunksPerRead = 100
+	// maxSettleRetries caps how often settling a replica is retried after a concurrent update
+	maxSettleRetries = 3
+)
+
+// Verdicts on a replica of a verified job
+const (
+	VerdictMajority  = "majority"  // Agreed with the result that was delivered
+	VerdictDissent   = "dissent"   // Disagreed with the result that was delivered
+	VerdictUndecided = "undecided" // No result had a majority
+	VerdictNoResult  = "no_result" // The replica has no output to compare
+)
+
+// VerificationOptions asks for a job to be run on several independent
+// sellers, delivering the result most of them agree on
+type VerificationOptions struct {
+	Replicas int `json:"replicas,omitempty"` // Sellers to run the job on, 3 by default
+}
+
+// Validate checks the options and fills in the defaults
+func (o *VerificationOptions) Validate() error {
+	if o == nil {
+		return nil
+	}
+	if o.Replicas == 0 {
+		o.Replicas = defaultReplicas
+	}
+	if o.Replicas < 2 || o.Replicas > maxReplicas {
+		return fmt.Errorf("verification replicas must be between 2 and %d", maxReplicas)
+	}
+	return nil
+}
+
+// ReplicaOutcome is how one replica of a verified job ended
+type ReplicaOutcome struct {
+	JobID           string   `json:"job_id"`
+	SellerID        string   `json:"seller_id,omitempty"`
+	State           JobState `json:"state"`
+	ExitCode        int      `json:"exit_code"`
+	StdoutSHA256    string   `json:"stdout_sha256,omitempty"`    // Hash of the normalized stdout
+	ArtifactsSHA256 string   `json:"artifacts_sha256,omitempty"` // Hash of the artifact manifest
+	Digest          string   `json:"digest,omitempty"`           // Hash of the exit code, stdout and artifacts; empty without a result to compare
+	Verdict         string   `json:"verdict"`
+}
+
+// ConsensusReport tells the buyer how the results of a verified job's replicas compared
+type ConsensusReport struct {
+	Replicas      int              `json:"replicas"`                 // Replicas the job ran on
+	Agreeing      int              `json:"agreeing"`                 // Replicas in the largest group with the same digest
+	Reached       bool             `json:"reached"`                  // Whether that group is more than half the replicas
+	Digest        string           `json:"digest,omitempty"`         // Digest of the delivered result
+	DeliveredFrom string           `json:"delivered_from,omitempty"` // Replica whose result was delivered
+	Outcomes      []ReplicaOutcome `json:"outcomes"`
+	ConcludedAt   int64            `json:"concluded_at"`
+}
+
+// dissented reports whether the replica disagreed with the delivered result
+func (c *ConsensusReport) dissented(jobID string) bool {
+	if c == nil {
+		return false
+	}
+	for _, o := range c.Outcomes {
+		if o.JobID == jobID {
+			return o.Verdict == VerdictDissent
+		}
+	}
+	return false
+}
+
+// replicaID is the job ID of the nth replica of a verified job
+func replicaID(jobID string, n int) string {
+	return fmt.Sprintf("%s-r%d", jobID, n)
+}
+
+// submitVerified stores a verified job together with its replicas and offers
+// every replica to the eligible sellers with a free slot. Each replica holds
+// its own share of the escrow, reserved from the buyer in one go. The inputs
+// are stored once, for the verified job, and shared by its replicas.
+func submitVerified(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, job *JobRequest, inputs *JobInputs, eligible []*SellerRecord) (string, error) {
+	n := job.Verification.Replicas
+	if len(eligible) < n {
+		logger.Warn("Job %s needs %d sellers for verification, only %d are eligible", job.JobID, n, len(eligible))
+		return "", fmt.Errorf("verification needs %d independent sellers but only %d can run this job", n, len(eligible))
+	}
+
+	// The verified job is never queued itself; it runs as soon as its replicas are stored
+	parent := newJob(*job)
+	parent.inputs = inputs
+	parent.State = JobRunning
+	parent.History = []JobTransition{{State: JobRunning, At: parent.CreatedAt, Reason: fmt.Sprintf("running on %d replicas", n)}}
+	escrow := maxJobCost(job, eligible)
+	free := withFreeSlots(eligible)
+	records := []*Job{parent}
+	for i := 1; i <= n; i++ {
+		req := *job
+		req.JobID = replicaID(job.JobID, i)
+		req.Verification = nil
+		replica := newJob(req)
+		replica.ReplicaOf = job.JobID
+		replica.Billing = &Billing{Escrow: escrow}
+		replica.markOffered(free)
+		parent.Replicas = append(parent.Replicas, req.JobID)
+		records = append(records, replica)
+	}
+
+	// The whole reservation is taken in the transaction that stores the jobs
+	total := escrow * int64(n)
+	parent.reserveEscrow(total)
+	if err := createJobs(ctx, nk, records); err != nil {
+		if err == errInsufficientCredits {
+			return "", fmt.Errorf("%w (%d credits for %d replicas)", err, total, n)
+		}
+		if err == errJobExists {
+			return "", err
+		}
+		logger.Error("Failed to store job %s: %v", job.JobID, err)
+		return "", errors.New("failed to store job")
+	}
+
+	// A replica that cannot be offered now is offered again by the sweeper
+	if len(free) > 0 {
+		for _, replica := range records[1:] {
+			if err := offerJob(ctx, nk, &replica.Request, free); err != nil {
+				logger.Error("Failed to offer replica %s to sellers: %v", replica.Request.JobID, err)
+			}
+		}
+	}
+
+	logger.Info("Job %s offered to %d seller(s) for verification on %d replicas", job.JobID, len(free), n)
+	return job.JobID, nil
+}
+
+// readReplicas loads the replicas of a verified job, in order
+func readReplicas(ctx context.Context, nk runtime.NakamaModule, parent *Job) ([]*Job, error) {
+	reads := make([]*runtime.StorageRead, 0, len(parent.Replicas))
+	for _, id := range parent.Replicas {
+		reads = append(reads, &runtime.StorageRead{Collection: jobCollection, Key: id})
+	}
+	objects, err := nk.StorageRead(ctx, reads)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]*Job, len(objects))
+	for _, obj := range objects {
+		job, err := decodeJob(obj.Value)
+		if err != nil {
+			return nil, err
+		}
+		byID[obj.Key] = job
+	}
+	replicas := make([]*Job, 0, len(parent.Replicas))
+	for _, id := range parent.Replicas {
+		job, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("replica %s: %w", id, errJobNotFound)
+		}
+		replicas = append(replicas, job)
+	}
+	return replicas, nil
+}
+
+// replicaSellers returns the sellers that claimed the other replicas of a job
+func replicaSellers(ctx context.Context, nk runtime.NakamaModule, job *Job) ([]string, error) {
+	parent, _, err := readJob(ctx, nk, job.ReplicaOf)
+	if err != nil {
+		return nil, err
+	}
+	return parent.sellersBesides(job.Request.JobID), nil
+}
+
+// sellersBesides returns the sellers that claimed the verified job's replicas other than the given one
+func (j *Job) sellersBesides(replicaID string) []string {
+	var sellers []string
+	for id, seller := range j.ReplicaSellers {
+		if id != replicaID {
+			sellers = append(sellers, seller)
+		}
+	}
+	return sellers
+}
+
+// concludeReplica concludes the verified job a replica belongs to once the replica has finished
+func concludeReplica(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, replica *Job) {
+	if err := concludeVerification(ctx, logger, nk, replica.ReplicaOf); err != nil && err != errJobConflict {
+		logger.Error("Failed to conclude verification of job %s: %v", replica.ReplicaOf, err)
+	}
+}
+
+// concludeVerification compares the replicas of a verified job once all of
+// them have finished, delivers the majority result to the buyer and flags
+// the sellers that disagreed with it. It then settles every finished replica,
+// so calling it again completes a settlement that was interrupted.
+func concludeVerification(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, jobID string) error {
+	parent, version, err := readJob(ctx, nk, jobID)
+	if err != nil {
+		return err
+	}
+	replicas, err := readReplicas(ctx, nk, parent)
+	if err != nil {
+		return err
+	}
+
+	if !parent.State.Terminal() {
+		for _, r := range replicas {
+			if !r.State.Terminal() {
+				return nil
+			}
+		}
+		report, err := compareReplicas(ctx, nk, replicas)
+		if err != nil {
+			return err
+		}
+		result := consensusResult(parent, replicas, report)
+		if err := parent.transition(result.State, fmt.Sprintf("%d of %d replicas agreed", report.Agreeing, report.Replicas)); err != nil {
+			return err
+		}
+		parent.Result = result
+		if err := writeJob(ctx, nk, parent, version); err != nil {
+			return err
+		}
+
+		for _, o := range report.Outcomes {
+			if o.Verdict == VerdictDissent {
+				logger.Warn("Seller %s disagreed with the majority on job %s", o.SellerID, jobID)
+				updateReputation(ctx, logger, nk, o.SellerID, func(r *Reputation) { r.Disagreements++ })
+			}
+		}
+		if err := notifyBuyer(ctx, nk, result, "Job Verified"); err != nil {
+			logger.Error("Failed to send notification to buyer: %v", err)
+		}
+		logger.Info("Verification of job %s concluded: %d of %d replicas agreed", jobID, report.Agreeing, report.Replicas)
+	}
+
+	var report *ConsensusReport
+	if parent.Result != nil {
+		report = parent.Result.Consensus
+	}
+	for _, id := range parent.Replicas {
+		settleReplica(ctx, logger, nk, id, report.dissented(id))
+	}
+	return nil
+}
+
+// compareReplicas hashes the result of every replica and finds the result
+// held by more than half of them, if there is one
+func compareReplicas(ctx context.Context, nk runtime.NakamaModule, replicas []*Job) (*ConsensusReport, error) {
+	report := &ConsensusReport{Replicas: len(replicas), ConcludedAt: time.Now().Unix()}
+	counts := make(map[string]int)
+	for _, r := range replicas {
+		o := ReplicaOutcome{JobID: r.Request.JobID, SellerID: r.SellerID, State: r.State, Verdict: VerdictNoResult}
+		if r.Result != nil {
+			o.SellerID = r.Result.SellerID
+			o.ExitCode = r.Result.ExitCode
+		}
+		// Only results of containers that actually ran are compared
+		if (r.State == JobSucceeded || r.State == JobFailed) && r.Result != nil && !sellerAtFault(r.Result) {
+			stdout, err := hashStdout(ctx, nk, r)
+			if err != nil {
+				return nil, err
+			}
+			o.StdoutSHA256 = stdout
+			o.ArtifactsSHA256 = hashManifest(r.Result.Artifacts)
+			o.Digest = sha256Hex([]byte(fmt.Sprintf("exit_code=%d\nstdout=%s\nartifacts=%s\n", o.ExitCode, o.StdoutSHA256, o.ArtifactsSHA256)))
+			counts[o.Digest]++
+		}
+		report.Outcomes = append(report.Outcomes, o)
+	}
+
+	// The largest group wins only if no other group is as large
+	tie := false
+	for digest, count := range counts {
+		if count > report.Agreeing {
+			report.Agreeing, report.Digest, tie = count, digest, false
+		} else if count == report.Agreeing {
+			tie = true
+		}
+	}
+	report.Reached = !tie && report.Agreeing*2 > report.Replicas
+	if !report.Reached {
+		report.Digest = ""
+	}
+
+	for i := range report.Outcomes {
+		o := &report.Outcomes[i]
+		switch {
+		case o.Digest == "":
+		case !report.Reached:
+			o.Verdict = VerdictUndecided
+		case o.Digest == report.Digest:
+			o.Verdict = VerdictMajority
+			if report.DeliveredFrom == "" {
+				report.DeliveredFrom = o.JobID
+			}
+		default:
+			o.Verdict = VerdictDissent
+		}
+	}
+	return report, nil
+}
+
+// consensusResult builds the result delivered to the buyer of a verified
+// job: the majority replica's result, or a failure when there is no majority
+func consensusResult(parent *Job, replicas []*Job, report *ConsensusReport) *JobResult {
+	for _, r := range replicas {
+		if r.Request.JobID == report.DeliveredFrom {
+			result := *r.Result
+			result.JobID = parent.Request.JobID
+			result.Consensus = report
+			return &result
+		}
+	}
+	return &JobResult{
+		JobID:     parent.Request.JobID,
+		BuyerID:   parent.Request.BuyerID,
+		Error:     fmt.Sprintf("verification failed: no result was shared by more than half of the %d replicas", report.Replicas),
+		ExitCode:  -1,
+		Timestamp: time.Now().Unix(),
+		State:     JobFailed,
+		Consensus: report,
+	}
+}
+
+// settleReplica pays the seller of a finished replica for its result, or
+// nothing if it disagreed with the delivered result, and refunds the rest
+func settleReplica(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, jobID string, dissented bool) {
+	for attempt := 1; ; attempt++ {
+		job, version, err := readJob(ctx, nk, jobID)
+		if err != nil {
+			logger.Error("Failed to read replica %s: %v", jobID, err)
+			return
+		}
+		if job.Result == nil || job.Billing == nil || job.Billing.SettledAt != 0 {
+			return
+		}
+		if dissented {
+			job.settle(0, "", "result disagreed with the majority of replicas")
+		} else {
+			earned, reason := sellerEarnings(job, job.Result)
+			job.settle(earned, job.Result.SellerID, reason)
+		}
+
+		err = writeJob(ctx, nk, job, version)
+		if err == errJobConflict && attempt < maxSettleRetries {
+			continue
+		}
+		if err != nil {
+			logger.Error("Failed to store settlement of replica %s: %v", jobID, err)
+		}
+		return
+	}
+}
+
+// cancelReplicas cancels the unfinished replicas of a cancelled verified job and settles the finished ones
+func cancelReplicas(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, parent *Job, reason string) {
+	for _, id := range parent.Replicas {
+		replica, version, err := readJob(ctx, nk, id)
+		if err != nil {
+			logger.Error("Failed to read replica %s: %v", id, err)
+			continue
+		}
+		if replica.State.Terminal() {
+			continue
+		}
+		if _, err := cancelJob(ctx, logger, nk, replica, version, reason); err != nil {
+			logger.Error("Failed to cancel replica %s: %v", id, err)
+		}
+	}
+	for _, id := range parent.Replicas {
+		settleReplica(ctx, logger, nk, id, false)
+	}
+}
+
+// hashStdout hashes a replica's normalized stdout, inline part and overflow alike
+func hashStdout(ctx context.Context, nk runtime.NakamaModule, job *Job) (string, error) {
+	h := sha256.New()
+	n := &lineNormalizer{w: h}
+	n.Write([]byte(job.Result.Stdout))
+
+	chunks := job.Result.Overflow["stdout"].Chunks
+	for start := 0; start < chunks; start += outputChunksPerRead {
+		end := start + outputChunksPerRead
+		if end > chunks {
+			end = chunks
+		}
+		reads := make([]*runtime.StorageRead, 0, end-start)
+		for i := start; i < end; i++ {
+			reads = append(reads, &runtime.StorageRead{
+				Collection: jobOutputCollection,
+				Key:        outputChunkKey(job.Request.JobID, job.Attempts, "stdout", i),
+			})
+		}
+		objects, err := nk.StorageRead(ctx, reads)
+		if err != nil {
+			return "", err
+		}
+		byKey := make(map[string]string, len(objects))
+		for _, obj := range objects {
+			byKey[obj.Key] = obj.Value
+		}
+
+		// A missing or unreadable chunk leaves a gap, so the hash will not match an intact copy
+		for _, r := range reads {
+			var chunk outputChunk
+			if err := json.Unmarshal([]byte(byKey[r.Key]), &chunk); err != nil {
+				continue
+			}
+			if data, err := base64.StdEncoding.DecodeString(chunk.Data); err == nil {
+				n.Write(data)
+			}
+		}
+	}
+	n.close()
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashManifest hashes the path, size and checksum of every artifact file, in path order
+func hashManifest(artifacts *Artifacts) string {
+	if artifacts == nil || len(artifacts.Files) == 0 {
+		return ""
+	}
+	files := append(artifacts.Files[:0:0], artifacts.Files...)
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	h := sha256.New()
+	for _, f := range files {
+		fmt.Fprintf(h, "%s\x00%d\x00%s\n", f.Path, f.Size, f.SHA256)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// sha256Hex returns the hex SHA-256 of b
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// lineNormalizer writes text to a hash with line endings unified, trailing
+// whitespace removed from every line and trailing blank lines dropped, so
+// output differing only in those respects hashes the same
+type lineNormalizer struct {
+	w      hash.Hash
+	line   []byte
+	blanks int // Blank lines held back until a non-blank line follows
+}
+
+func (n *lineNormalizer) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			n.line = append(n.line, p...)
+			break
+		}
+		n.line = append(n.line, p[:i]...)
+		n.endLine()
+		p = p[i+1:]
+	}
+	return written, nil
+}
+
+// endLine writes the buffered line
+func (n *lineNormalizer) endLine() {
+	line := bytes.TrimRight(n.line, " \t\r")
+	n.line = n.line[:0]
+	if len(line) == 0 {
+		n.blanks++
+		return
+	}
+	for ; n.blanks > 0; n.blanks-- {
+		n.w.Write([]byte{'\n'})
+	}
+	n.w.Write(line)
+	n.w.Write([]byte{'\n'})
+}
+
+// close writes a last line that had no newline
+func (n *lineNormalizer) close() {
+	if len(n.line) > 0 {
+		n.endLine()
+	}
+}
@@ -0,0 +1,263 @@
+package modules
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// submitTestVerified stores a verified job with the given number of replicas, offered to the sellers
+func submitTestVerified(t *testing.T, nk *fakeNakama, replicas int, sellers ...*SellerRecord) {
+	t.Helper()
+	ctx := context.Background()
+	for _, s := range sellers {
+		if err := writeSeller(ctx, nk, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	job := &JobRequest{
+		JobID:        "job-1",
+		BuyerID:      "buyer-1",
+		Image:        "python:3.10",
+		Resources:    Resources{TimeoutSeconds: 30}.WithDefaults(),
+		Verification: &VerificationOptions{Replicas: replicas},
+	}
+	if _, err := submitVerified(ctx, fakeLogger{}, nk, job, nil, sellers); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// claim calls claim_job as the seller
+func claim(nk *fakeNakama, sellerID, jobID string) error {
+	_, err := ClaimJob(asUser(sellerID), fakeLogger{}, nil, nk, fmt.Sprintf(`{"job_id": %q}`, jobID))
+	return err
+}
+
+func TestSellerClaimsOnlyOneReplicaAtOnce(t *testing.T) {
+	nk := newFakeNakama()
+	submitTestVerified(t, nk, 2,
+		&SellerRecord{UserID: "seller-1", Slots: 2, FreeSlots: 2},
+		&SellerRecord{UserID: "seller-2", Slots: 2, FreeSlots: 2},
+	)
+
+	// Both claims read the jobs before either writes, so neither sees the other
+	var arrived sync.WaitGroup
+	arrived.Add(2)
+	nk.beforeWrite = func() {
+		arrived.Done()
+		arrived.Wait()
+	}
+	errs := make([]error, 2)
+	var claims sync.WaitGroup
+	for i, id := range []string{"job-1-r1", "job-1-r2"} {
+		claims.Add(1)
+		go func(i int, id string) {
+			defer claims.Done()
+			errs[i] = claim(nk, "seller-1", id)
+		}(i, id)
+	}
+	claims.Wait()
+	nk.beforeWrite = nil
+
+	var won, lost string
+	switch {
+	case errs[0] == nil && errs[1] != nil:
+		won, lost = "job-1-r1", "job-1-r2"
+	case errs[1] == nil && errs[0] != nil:
+		won, lost = "job-1-r2", "job-1-r1"
+	default:
+		t.Fatalf("want exactly one replica claimed by seller-1, got errors %v", errs)
+	}
+
+	parent, _, err := readJob(context.Background(), nk, "job-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := parent.ReplicaSellers[won]; got != "seller-1" || len(parent.ReplicaSellers) != 1 {
+		t.Errorf("want only %s recorded for seller-1, got %v", won, parent.ReplicaSellers)
+	}
+
+	// Once the race is over the seller is still refused, and another seller takes the replica
+	if err := claim(nk, "seller-1", lost); err == nil {
+		t.Errorf("want seller-1 refused %s", lost)
+	}
+	if err := claim(nk, "seller-2", lost); err != nil {
+		t.Errorf("want seller-2 to claim %s, got %v", lost, err)
+	}
+}
+
+// replica returns a finished replica whose container ran and printed stdout
+func replica(n int, exitCode int, stdout string) *Job {
+	id := replicaID("job-1", n)
+	seller := fmt.Sprintf("seller-%d", n)
+	state := JobSucceeded
+	if exitCode != 0 {
+		state = JobFailed
+	}
+	return &Job{
+		Request:   JobRequest{JobID: id, BuyerID: "buyer-1"},
+		State:     state,
+		ReplicaOf: "job-1",
+		Result:    &JobResult{JobID: id, SellerID: seller, ExitCode: exitCode, Stdout: stdout, Usage: &Usage{StartedAt: 1}},
+	}
+}
+
+func TestCompareReplicas(t *testing.T) {
+	expired := &Job{Request: JobRequest{JobID: replicaID("job-1", 3)}, State: JobExpired, ReplicaOf: "job-1"}
+	neverRan := replica(3, -1, "")
+	neverRan.Result.Usage = nil
+
+	tests := []struct {
+		name      string
+		replicas  []*Job
+		reached   bool
+		agreeing  int
+		delivered string
+		verdicts  []string
+	}{
+		{
+			name:      "majority",
+			replicas:  []*Job{replica(1, 0, "42\n"), replica(2, 0, "41\n"), replica(3, 0, "42\n")},
+			reached:   true,
+			agreeing:  2,
+			delivered: "job-1-r1",
+			verdicts:  []string{VerdictMajority, VerdictDissent, VerdictMajority},
+		},
+		{
+			name:     "tie",
+			replicas: []*Job{replica(1, 0, "a\n"), replica(2, 0, "a\n"), replica(3, 0, "b\n"), replica(4, 0, "b\n")},
+			agreeing: 2,
+			verdicts: []string{VerdictUndecided, VerdictUndecided, VerdictUndecided, VerdictUndecided},
+		},
+		{
+			name:     "all differ",
+			replicas: []*Job{replica(1, 0, "a\n"), replica(2, 0, "b\n"), replica(3, 0, "c\n")},
+			agreeing: 1,
+			verdicts: []string{VerdictUndecided, VerdictUndecided, VerdictUndecided},
+		},
+		{
+			name:     "exit code counts",
+			replicas: []*Job{replica(1, 0, "a\n"), replica(2, 1, "a\n")},
+			agreeing: 1,
+			verdicts: []string{VerdictUndecided, VerdictUndecided},
+		},
+		{
+			name:      "expired replica still leaves a majority",
+			replicas:  []*Job{replica(1, 0, "a\n"), replica(2, 0, "a\n"), expired},
+			reached:   true,
+			agreeing:  2,
+			delivered: "job-1-r1",
+			verdicts:  []string{VerdictMajority, VerdictMajority, VerdictNoResult},
+		},
+		{
+			name:     "failed replica is no majority of its own",
+			replicas: []*Job{replica(1, 0, "a\n"), replica(2, 0, "b\n"), neverRan},
+			agreeing: 1,
+			verdicts: []string{VerdictUndecided, VerdictUndecided, VerdictNoResult},
+		},
+		{
+			name:      "agreeing failures are a majority",
+			replicas:  []*Job{replica(1, 2, "boom\n"), replica(2, 2, "boom\n"), replica(3, 0, "ok\n")},
+			reached:   true,
+			agreeing:  2,
+			delivered: "job-1-r1",
+			verdicts:  []string{VerdictMajority, VerdictMajority, VerdictDissent},
+		},
+	}
+	for _, tt := range tests {
+		report, err := compareReplicas(context.Background(), newFakeNakama(), tt.replicas)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if report.Reached != tt.reached || report.Agreeing != tt.agreeing || report.DeliveredFrom != tt.delivered {
+			t.Errorf("%s: got reached %v, agreeing %d, delivered from %q", tt.name, report.Reached, report.Agreeing, report.DeliveredFrom)
+		}
+		if !tt.reached && report.Digest != "" {
+			t.Errorf("%s: want no digest without a majority", tt.name)
+		}
+		for i, o := range report.Outcomes {
+			if o.Verdict != tt.verdicts[i] {
+				t.Errorf("%s: replica %s verdict %s, want %s", tt.name, o.JobID, o.Verdict, tt.verdicts[i])
+			}
+		}
+	}
+}
+
+func TestConsensusResult(t *testing.T) {
+	parent := &Job{Request: JobRequest{JobID: "job-1", BuyerID: "buyer-1"}}
+	replicas := []*Job{replica(1, 0, "a\n"), replica(2, 0, "b\n"), replica(3, 0, "b\n")}
+
+	report, err := compareReplicas(context.Background(), newFakeNakama(), replicas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := consensusResult(parent, replicas, report)
+	if result.JobID != "job-1" || result.SellerID != "seller-2" || result.Stdout != "b\n" || result.Consensus != report {
+		t.Errorf("want the majority replica's result under the verified job's ID, got %+v", result)
+	}
+	if replicas[1].Result.JobID != "job-1-r2" {
+		t.Error("want the replica's own result left untouched")
+	}
+
+	replicas[2].Result.Stdout = "c\n"
+	report, err = compareReplicas(context.Background(), newFakeNakama(), replicas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result = consensusResult(parent, replicas, report)
+	if result.State != JobFailed || result.ExitCode != -1 || !strings.Contains(result.Error, "no result was shared by more than half of the 3 replicas") {
+		t.Errorf("want a failed result without a majority, got %+v", result)
+	}
+}
+
+func TestHashStdoutNormalizesLines(t *testing.T) {
+	hash := func(job *Job) string {
+		t.Helper()
+		h, err := hashStdout(context.Background(), newFakeNakama(), job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	same := []string{"a\nb\n", "a\nb", "a\r\nb\r\n", "a  \nb\t\n", "a\nb\n\n\n", "a\nb\n  \n"}
+	want := hash(replica(1, 0, same[0]))
+	for _, stdout := range same[1:] {
+		if got := hash(replica(1, 0, stdout)); got != want {
+			t.Errorf("want %q to hash like %q", stdout, same[0])
+		}
+	}
+	for _, stdout := range []string{"a\n\nb\n", "b\na\n", " a\nb\n", "ab\n"} {
+		if got := hash(replica(1, 0, stdout)); got == want {
+			t.Errorf("want %q to hash differently from %q", stdout, same[0])
+		}
+	}
+}
+
+func TestHashStdoutReadsOverflow(t *testing.T) {
+	nk := newFakeNakama()
+	job := replica(1, 0, "hello ")
+	job.Result.Overflow = map[string]OutputOverflow{"stdout": {Bytes: 12, Chunks: 2}}
+	for i, data := range []string{"wor", "ld  \r\n"} {
+		value, _ := json.Marshal(outputChunk{Data: base64.StdEncoding.EncodeToString([]byte(data))})
+		nk.StorageWrite(context.Background(), []*runtime.StorageWrite{{
+			Collection: jobOutputCollection,
+			Key:        outputChunkKey(job.Request.JobID, job.Attempts, "stdout", i),
+			Value:      string(value),
+		}})
+	}
+
+	got, err := hashStdout(context.Background(), nk, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := hashStdout(context.Background(), nk, replica(2, 0, "hello world\n"))
+	if got != want {
+		t.Error("want the overflow chunks hashed after the inline output")
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 
+	"github.com/google/uuid"
 	// Required for Nakama API client
 	"github.com/heroiclabs/nakama-common/runtime"
 	// Required for grpc.Dial
@@ -31,11 +33,118 @@ func InitModule(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runti
 		return err
 	}
 
+	// Register RPC for sellers to keep their registration alive
+	if err := initializer.RegisterRpc("seller_heartbeat", SellerHeartbeat); err != nil {
+		logger.Error("Unable to register seller_heartbeat RPC: %v", err)
+		return err
+	}
+
+	// Register RPC for sellers to leave the registry when they shut down
+	if err := initializer.RegisterRpc("deregister_seller", DeregisterSeller); err != nil {
+		logger.Error("Unable to register deregister_seller RPC: %v", err)
+		return err
+	}
+
+	// Register RPC to list the sellers currently online
+	if err := initializer.RegisterRpc("list_sellers", ListSellers); err != nil {
+		logger.Error("Unable to register list_sellers RPC: %v", err)
+		return err
+	}
+
+	// Register RPC for sellers to take exclusive ownership of an offered job
+	if err := initializer.RegisterRpc("claim_job", ClaimJob); err != nil {
+		logger.Error("Unable to register claim_job RPC: %v", err)
+		return err
+	}
+
+	// Register RPC for sellers to keep the lease on a job they are running
+	if err := initializer.RegisterRpc("renew_job_lease", RenewJobLease); err != nil {
+		logger.Error("Unable to register renew_job_lease RPC: %v", err)
+		return err
+	}
+
+	// Register RPCs to stream job output while it runs
+	if err := initializer.RegisterRpc("append_job_log", AppendJobLog); err != nil {
+		logger.Error("Unable to register append_job_log RPC: %v", err)
+		return err
+	}
+
+	if err := initializer.RegisterRpc("get_job_logs", GetJobLogs); err != nil {
+		logger.Error("Unable to register get_job_logs RPC: %v", err)
+		return err
+	}
+
+	// Register RPCs for output too large to carry in a JobResult
+	if err := initializer.RegisterRpc("upload_job_output", UploadJobOutput); err != nil {
+		logger.Error("Unable to register upload_job_output RPC: %v", err)
+		return err
+	}
+
+	if err := initializer.RegisterRpc("get_job_output", GetJobOutput); err != nil {
+		logger.Error("Unable to register get_job_output RPC: %v", err)
+		return err
+	}
+
+	// Register RPCs for placing jobs by auction
+	if err := initializer.RegisterRpc("bid_job", BidJob); err != nil {
+		logger.Error("Unable to register bid_job RPC: %v", err)
+		return err
+	}
+
+	if err := initializer.RegisterRpc("accept_bid", AcceptBid); err != nil {
+		logger.Error("Unable to register accept_bid RPC: %v", err)
+		return err
+	}
+
+	// Register RPCs for seller reputation
+	if err := initializer.RegisterRpc("dispute_job", DisputeJob); err != nil {
+		logger.Error("Unable to register dispute_job RPC: %v", err)
+		return err
+	}
+
+	if err := initializer.RegisterRpc("get_seller_reputation", GetSellerReputation); err != nil {
+		logger.Error("Unable to register get_seller_reputation RPC: %v", err)
+		return err
+	}
+
+	// Rank sellers by reputation; creating an existing leaderboard is a no-op
+	if err := nk.LeaderboardCreate(ctx, ReputationLeaderboard, true, "desc", "set", "", nil, true); err != nil {
+		logger.Error("Unable to create %s leaderboard: %v", ReputationLeaderboard, err)
+		return err
+	}
+
+	// Register RPC for buyers to cancel their jobs
+	if err := initializer.RegisterRpc("cancel_job", CancelJob); err != nil {
+		logger.Error("Unable to register cancel_job RPC: %v", err)
+		return err
+	}
+
+	// Register RPCs to look up jobs
+	if err := initializer.RegisterRpc("get_job_status", GetJobStatus); err != nil {
+		logger.Error("Unable to register get_job_status RPC: %v", err)
+		return err
+	}
+
+	if err := initializer.RegisterRpc("list_jobs", ListJobs); err != nil {
+		logger.Error("Unable to register list_jobs RPC: %v", err)
+		return err
+	}
+
+	// Requeue jobs whose seller stopped renewing its lease
+	stop := make(chan struct{})
+	go runLeaseSweeper(logger, nk, stop)
+	if err := initializer.RegisterShutdown(func(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule) {
+		close(stop)
+	}); err != nil {
+		logger.Error("Unable to register shutdown hook: %v", err)
+		return err
+	}
+
 	logger.Info("Compute marketplace module initialized")
 	return nil
 }
 
-// SendJobToSeller forwards a job request to all sellers
+// SendJobToSeller offers a job request to the sellers able to run it
 func SendJobToSeller(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
 	var job JobRequest
 	if err := json.Unmarshal([]byte(payload), &job); err != nil {
@@ -46,31 +155,117 @@ func SendJobToSeller(ctx context.Context, logger runtime.Logger, db *sql.DB, nk
 	if job.Image == "" || job.Command == "" {
 		return "", errors.New("job request must include image and command")
 	}
+	if job.MaxPrice < 0 {
+		return "", errors.New("max_price must not be negative")
+	}
+	if job.MinReputation < 0 || job.MinReputation > 1 {
+		return "", errors.New("min_reputation must be between 0 and 1")
+	}
+	if err := job.Auction.Validate(); err != nil {
+		return "", err
+	}
+	if job.Auction != nil && job.MaxPrice == 0 {
+		return "", errors.New("auctioned jobs require a max_price")
+	}
+	if err := job.Verification.Validate(); err != nil {
+		return "", err
+	}
+	if job.Verification != nil && job.Auction != nil {
+		return "", errors.New("verified jobs cannot be placed by auction")
+	}
 
-	// Send job request to "sellers" topic
-	topic := "sellers"
+	// Jobs always belong to the session user, whatever buyer_id the client sent
+	buyerID, err := sessionUserID(ctx, job.BuyerID)
+	if err != nil {
+		return "", err
+	}
+	job.BuyerID = buyerID
+	if job.JobID == "" {
+		job.JobID = uuid.New().String()
+	} else if !validJobID(job.JobID) {
+		return "", fmt.Errorf("job_id must be at most %d letters, digits, '_', '.' or '-', starting with a letter or digit", maxJobIDLength)
+	}
+	if err := job.Resources.Validate(); err != nil {
+		return "", err
+	}
+	job.Resources = job.Resources.WithDefaults()
+	if _, _, err := job.Inputs.Decode(); err != nil {
+		return "", err
+	}
+	// Inputs are stored apart from the job and never travel with offers
+	inputs := job.Inputs
+	job.Inputs = nil
 
-	// Create the job message
-	jobMsg := map[string]interface{}{
-		"type": "job_request",
-		"data": job,
+	eligible, err := findEligibleSellers(ctx, nk, &job, nil)
+	if err != nil {
+		logger.Error("Failed to list sellers: %v", err)
+		return "", errors.New("failed to distribute job")
+	}
+	if len(eligible) == 0 {
+		logger.Warn("No eligible seller for job %s (image %s, requirements %v, max price %d)", job.JobID, job.Image, job.Requirements, job.MaxPrice)
+		return "", errNoEligibleSeller
+	}
+	if job.Verification != nil {
+		return submitVerified(ctx, logger, nk, &job, inputs, eligible)
+	}
+
+	// Hold the most any capable seller could charge until the job is settled.
+	// An auction may be won by any bid up to the buyer's max price.
+	escrow := maxJobCost(&job, eligible)
+	if job.Auction != nil {
+		escrow = job.MaxPrice
+	}
+	record := newJob(job)
+	record.inputs = inputs
+	record.Billing = &Billing{Escrow: escrow}
+	record.reserveEscrow(escrow)
+	if job.Auction != nil {
+		// Busy sellers may bid too; their estimate covers the wait for a slot
+		record.openRound(eligible)
+	} else {
+		// When every capable seller is busy the job waits in the queue for the sweeper to offer it
+		eligible = withFreeSlots(eligible)
+		record.markOffered(eligible)
+	}
+	// The escrow is reserved in the same transaction that stores the job
+	if err := createJob(ctx, nk, record); err != nil {
+		if err == errInsufficientCredits {
+			return "", fmt.Errorf("%w (%d credits)", err, escrow)
+		}
+		if err == errJobExists {
+			return "", err
+		}
+		logger.Error("Failed to store job %s: %v", job.JobID, err)
+		return "", errors.New("failed to store job")
 	}
 
-	_, err := nk.ChannelMessageSend(ctx,
-		topic,
-		jobMsg,
-		"job_request",
-		"",
-		false,
-	)
-	// Check if the message was sent successfully
-	// In a real application, you would handle the error and retry logic
+	if len(eligible) == 0 {
+		logger.Info("All sellers able to run job %s are busy, queueing it", job.JobID)
+		return job.JobID, nil
+	}
+
+	if record.Auction != nil {
+		err = announceAuction(ctx, nk, record, eligible)
+	} else {
+		err = offerJob(ctx, nk, &job, eligible)
+	}
 	if err != nil {
 		logger.Error("Failed to send job to sellers: %v", err)
+		// Leave no queued job behind that nobody was told about
+		if terr := record.transition(JobExpired, "job could not be offered to sellers"); terr == nil {
+			record.settle(0, "", "job could not be offered to sellers")
+			if werr := storeJob(ctx, nk, record, ""); werr != nil {
+				logger.Error("Failed to store job %s: %v", job.JobID, werr)
+			}
+		}
 		return "", errors.New("failed to distribute job")
 	}
 
-	logger.Info("Job request sent to sellers. Job ID: %s", job.JobID)
+	if record.Auction != nil {
+		logger.Info("Job %s put up for auction among %d seller(s) until %d", job.JobID, len(eligible), record.Auction.ClosesAt)
+		return job.JobID, nil
+	}
+	logger.Info("Job request offered to %d seller(s). Job ID: %s", len(eligible), job.JobID)
 	return job.JobID, nil
 }
 
@@ -82,25 +277,93 @@ func SubmitJobResult(ctx context.Context, logger runtime.Logger, db *sql.DB, nk
 		return "", errors.New("invalid job result format")
 	}
 
-	if result.JobID == "" || result.BuyerID == "" || result.SellerID == "" {
-		return "", errors.New("job result must include job_id, buyer_id, and seller_id")
+	if result.JobID == "" {
+		return "", errors.New("job result must include job_id")
 	}
 
-	// Send result to buyer via notification
-	content := map[string]interface{}{
-		"type": "job_result",
-		"data": result,
+	// Results are attributed to the session user, never to a client-supplied seller_id
+	sellerID, err := sessionUserID(ctx, result.SellerID)
+	if err != nil {
+		return "", err
 	}
+	result.SellerID = sellerID
 
-	notification := &runtime.NotificationSend{
-		UserID:     result.BuyerID,
-		Subject:    "Job Completed",
-		Content:    content,
-		Code:       1,
-		Persistent: true,
+	if err := checkOutput(&result); err != nil {
+		return "", err
+	}
+	if err := result.Usage.Validate(); err != nil {
+		return "", err
 	}
 
-	if err := nk.NotificationsSend(ctx, []*runtime.NotificationSend{notification}); err != nil {
+	job, version, err := readJob(ctx, nk, result.JobID)
+	if err == errJobNotFound {
+		return "", err
+	}
+	if err != nil {
+		logger.Error("Failed to read job %s: %v", result.JobID, err)
+		return "", errors.New("failed to record job result")
+	}
+	if job.State.Terminal() {
+		return "", fmt.Errorf("job is already %s", job.State)
+	}
+
+	// Only the seller holding the lease may report the outcome
+	if job.State == JobQueued {
+		return "", errors.New("job has not been claimed")
+	}
+	if job.SellerID != result.SellerID {
+		return "", errors.New("job is assigned to another seller")
+	}
+	if err := result.Usage.Within(job.Request.Resources, job.claimedAt()); err != nil {
+		return "", err
+	}
+
+	if result.Interrupted {
+		status, err := requeueInterrupted(ctx, logger, nk, job, version)
+		if err == nil {
+			recordResult(ctx, logger, nk, job, &result)
+		}
+		return status, err
+	}
+
+	final := JobSucceeded
+	if result.ExitCode != 0 || result.Error != "" {
+		final = JobFailed
+	}
+	if err := job.transition(final, ""); err != nil {
+		return "", err
+	}
+	result.BuyerID = job.Request.BuyerID
+	result.State = final
+	job.Result = &result
+	job.Usage = result.Usage
+	job.LeaseExpiresAt = 0
+
+	// Pay the seller for what it measured and refund the rest of the escrow in
+	// the transaction that records the result. A replica is settled once its
+	// result has been compared with the others.
+	if job.ReplicaOf == "" {
+		earned, reason := sellerEarnings(job, &result)
+		job.settle(earned, result.SellerID, reason)
+	}
+
+	if err := writeJob(ctx, nk, job, version); err == errJobConflict {
+		return "", err
+	} else if err != nil {
+		logger.Error("Failed to store result of job %s: %v", result.JobID, err)
+		return "", errors.New("failed to record job result")
+	}
+	recordResult(ctx, logger, nk, job, &result)
+
+	// The buyer hears about the verified job, not its replicas
+	if job.ReplicaOf != "" {
+		logger.Info("Result of replica %s recorded", result.JobID)
+		concludeReplica(ctx, logger, nk, job)
+		return "result_recorded", nil
+	}
+
+	// Send result to buyer via notification
+	if err := notifyBuyer(ctx, nk, &result, "Job Completed"); err != nil {
 		logger.Error("Failed to send notification to buyer: %v", err)
 		return "", errors.New("failed to notify buyer")
 	}
@@ -109,18 +372,20 @@ func SubmitJobResult(ctx context.Context, logger runtime.Logger, db *sql.DB, nk
 	return "result_delivered", nil
 }
 
-// RegisterSeller is a dummy placeholder for seller registration
-func RegisterSeller(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
-	var seller struct {
-		UserID       string   `json:"user_id"`
-		Capabilities []string `json:"capabilities"`
+// notifyBuyer delivers a final job result to the buyer as a persistent notification
+func notifyBuyer(ctx context.Context, nk runtime.NakamaModule, result *JobResult, subject string) error {
+	content := map[string]interface{}{
+		"type": "job_result",
+		"data": result,
 	}
-	if err := json.Unmarshal([]byte(payload), &seller); err != nil {
-		logger.Error("Failed to parse seller registration: %v", err)
-		return "", errors.New("invalid seller registration format")
+
+	notification := &runtime.NotificationSend{
+		UserID:     result.BuyerID,
+		Subject:    subject,
+		Content:    content,
+		Code:       NotificationJobResult,
+		Persistent: true,
 	}
 
-	// In a real app, store this in a DB — this is a no-op placeholder
-	logger.Info("Seller registered: %s with capabilities: %v", seller.UserID, seller.Capabilities)
-	return "seller_registered", nil
+	return nk.NotificationsSend(ctx, []*runtime.NotificationSend{notification})
 }
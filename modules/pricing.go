@@ -0,0 +1,84 @@
+package modules
+
+import (
+	"errors"
+	"math"
+)
+
+// PriceCard is what a seller charges for a job, in wallet credits
+type PriceCard struct {
+	CPUSecond float64 `json:"cpu_second"` // Per CPU-second the job uses
+	GBSecond  float64 `json:"gb_second"`  // Per GB-second of memory the job reserves
+	Minimum   float64 `json:"minimum"`    // Charged at least, per job
+}
+
+// Validate rejects negative prices
+func (p *PriceCard) Validate() error {
+	if p == nil {
+		return nil
+	}
+	if p.CPUSecond < 0 || p.GBSecond < 0 || p.Minimum < 0 {
+		return errors.New("prices must not be negative")
+	}
+	return nil
+}
+
+// AgreedPrice is the price card a job is billed at. It is fixed when a
+// seller claims the job, so later changes to the seller's card do not apply.
+type AgreedPrice struct {
+	SellerID string    `json:"seller_id"`     // Seller the price was agreed with
+	Card     PriceCard `json:"card"`          // The seller's price card at the time of the claim
+	Bid      *Bid      `json:"bid,omitempty"` // Winning bid, whose flat price replaces the card, for auctioned jobs
+	MaxCost  int64     `json:"max_cost"`      // Most the job can cost at this card
+	AgreedAt int64     `json:"agreed_at"`     // When the seller claimed the job
+}
+
+// Cost returns what the job costs at the agreed price
+func (a *AgreedPrice) Cost(r Resources, u *Usage) int64 {
+	if a.Bid != nil {
+		return a.Bid.Price
+	}
+	return a.Card.Cost(r, u)
+}
+
+// CancellationFee is what the seller keeps when the buyer cancels the job
+// after it was claimed: the card's per-job minimum, never more than the bid
+func (a *AgreedPrice) CancellationFee(r Resources) int64 {
+	fee := a.Card.Cost(r, nil)
+	if a.Bid != nil && a.Bid.Price < fee {
+		fee = a.Bid.Price
+	}
+	return fee
+}
+
+// MaxCost returns the most the card charges for a job with the given
+// resources, if it uses every CPU it asked for until its timeout
+func (p *PriceCard) MaxCost(r Resources) int64 {
+	if p == nil {
+		return 0
+	}
+	seconds := float64(r.TimeoutSeconds)
+	return p.charge(r.CPUs*seconds, float64(r.MemoryMB)/1024*seconds)
+}
+
+// Cost returns what the card charges for a job with the given resources and
+// measured usage. Without usage only the minimum is charged.
+func (p *PriceCard) Cost(r Resources, u *Usage) int64 {
+	if p == nil {
+		return 0
+	}
+	if u == nil {
+		return p.charge(0, 0)
+	}
+	return p.charge(u.CPUSeconds, float64(r.MemoryMB)/1024*u.WallSeconds)
+}
+
+// charge prices CPU-seconds and GB-seconds, at least the minimum, rounded up to whole credits
+func (p *PriceCard) charge(cpuSeconds, gbSeconds float64) int64 {
+	cost := p.CPUSecond*cpuSeconds + p.GBSecond*gbSeconds
+	if cost < p.Minimum {
+		cost = p.Minimum
+	}
+	// Leave out floating point noise so an exact price is not rounded up
+	return int64(math.Ceil(cost - 1e-9))
+}
@@ -0,0 +1,95 @@
+package modules
+
+import "testing"
+
+func TestPriceCardCost(t *testing.T) {
+	resources := Resources{CPUs: 2, MemoryMB: 512, TimeoutSeconds: 100}
+	tests := []struct {
+		name  string
+		card  *PriceCard
+		usage *Usage
+		want  int64
+	}{
+		{"no card", nil, &Usage{CPUSeconds: 10, WallSeconds: 10}, 0},
+		{"cpu and memory", &PriceCard{CPUSecond: 1, GBSecond: 2}, &Usage{CPUSeconds: 10, WallSeconds: 20}, 30},
+		{"rounded up", &PriceCard{CPUSecond: 0.01}, &Usage{CPUSeconds: 150}, 2},
+		{"exact price not rounded up", &PriceCard{CPUSecond: 0.1}, &Usage{CPUSeconds: 30}, 3},
+		{"minimum charge", &PriceCard{CPUSecond: 0.01, Minimum: 5}, &Usage{CPUSeconds: 10}, 5},
+		{"above minimum", &PriceCard{CPUSecond: 1, Minimum: 5}, &Usage{CPUSeconds: 10}, 10},
+		{"no usage charges the minimum", &PriceCard{CPUSecond: 1, GBSecond: 1, Minimum: 3}, nil, 3},
+		{"no usage and no minimum", &PriceCard{CPUSecond: 1}, nil, 0},
+		{"fractional minimum", &PriceCard{Minimum: 0.2}, &Usage{}, 1},
+	}
+	for _, tt := range tests {
+		if got := tt.card.Cost(resources, tt.usage); got != tt.want {
+			t.Errorf("%s: Cost = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPriceCardMaxCost(t *testing.T) {
+	card := &PriceCard{CPUSecond: 0.5, GBSecond: 1, Minimum: 10}
+	tests := []struct {
+		resources Resources
+		want      int64
+	}{
+		// 2 CPUs for 100s at 0.5, plus 0.5 GB for 100s at 1
+		{Resources{CPUs: 2, MemoryMB: 512, TimeoutSeconds: 100}, 150},
+		{Resources{CPUs: 0.5, MemoryMB: 1024, TimeoutSeconds: 3}, 10},
+		{Resources{CPUs: 1, MemoryMB: 100, TimeoutSeconds: 21}, 13},
+	}
+	for _, tt := range tests {
+		if got := card.MaxCost(tt.resources); got != tt.want {
+			t.Errorf("MaxCost(%+v) = %d, want %d", tt.resources, got, tt.want)
+		}
+	}
+
+	var none *PriceCard
+	if got := none.MaxCost(Resources{CPUs: 1, TimeoutSeconds: 10}); got != 0 {
+		t.Errorf("want a seller without a card free, got %d", got)
+	}
+
+	// Whatever the job uses within its resources costs at most the max
+	r := Resources{CPUs: 2, MemoryMB: 512, TimeoutSeconds: 100}
+	if cost := card.Cost(r, &Usage{CPUSeconds: 200, WallSeconds: 100}); cost != card.MaxCost(r) {
+		t.Errorf("want full use to cost the max of %d, got %d", card.MaxCost(r), cost)
+	}
+}
+
+func TestPriceCardValidate(t *testing.T) {
+	var none *PriceCard
+	if err := none.Validate(); err != nil {
+		t.Errorf("want no card accepted, got %v", err)
+	}
+	if err := (&PriceCard{CPUSecond: 1, Minimum: 2}).Validate(); err != nil {
+		t.Errorf("want a valid card accepted, got %v", err)
+	}
+	for _, card := range []PriceCard{{CPUSecond: -1}, {GBSecond: -0.1}, {Minimum: -5}} {
+		if err := card.Validate(); err == nil {
+			t.Errorf("want %+v refused", card)
+		}
+	}
+}
+
+func TestAgreedPrice(t *testing.T) {
+	r := Resources{CPUs: 1, MemoryMB: 1024, TimeoutSeconds: 60}
+	card := PriceCard{CPUSecond: 1, Minimum: 4}
+	usage := &Usage{CPUSeconds: 30, WallSeconds: 30}
+
+	metered := &AgreedPrice{Card: card}
+	if got := metered.Cost(r, usage); got != 30 {
+		t.Errorf("want the card's price, got %d", got)
+	}
+	if got := metered.CancellationFee(r); got != 4 {
+		t.Errorf("want the card's minimum as the cancellation fee, got %d", got)
+	}
+
+	// A winning bid replaces the card, and the fee never exceeds it
+	bid := &AgreedPrice{Card: card, Bid: &Bid{Price: 3}}
+	if got := bid.Cost(r, usage); got != 3 {
+		t.Errorf("want the bid's price, got %d", got)
+	}
+	if got := bid.CancellationFee(r); got != 3 {
+		t.Errorf("want the fee capped at the bid, got %d", got)
+	}
+}
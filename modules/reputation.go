@@ -0,0 +1,371 @@
+package modules
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	// reputationCollection holds one system-owned reputation record per seller
+	reputationCollection = "seller_reputation"
+	// ReputationLeaderboard ranks sellers by reputation score
+	ReputationLeaderboard = "seller_reputation"
+
+	// NeutralReputation is the score of a seller without a track record
+	NeutralReputation = 0.5
+	// reputationScale turns a score into the integer written to the leaderboard
+	reputationScale = 10000
+	// latencyHalfScore is the average start latency (in seconds) that halves the speed part of the score
+	latencyHalfScore = 60
+	// latencyWeight is the share of the score given to start latency; the rest is reliability
+	latencyWeight = 0.2
+	// maxReputationRetries caps how often a conflicting reputation update is retried
+	maxReputationRetries = 3
+
+	// disputeCollection holds one system-owned record per buyer of the disputes it recently filed
+	disputeCollection = "buyer_disputes"
+	// disputeWindowSeconds is how long after a job finishes its result may be disputed
+	disputeWindowSeconds = 24 * 60 * 60
+	// maxDisputes caps how many disputes a buyer may file within disputeWindowSeconds
+	maxDisputes = 5
+)
+
+var errDisputeLimit = fmt.Errorf("at most %d disputes may be filed per %d hours", maxDisputes, disputeWindowSeconds/3600)
+
+// Reputation is the track record of a seller
+type Reputation struct {
+	SellerID      string  `json:"seller_id"`
+	Delivered     int64   `json:"delivered"`      // Results of jobs the seller ran, whatever the job's exit code
+	Faults        int64   `json:"faults"`         // Results of jobs the seller never ran
+	LeaseExpiries int64   `json:"lease_expiries"` // Jobs taken back because the seller stopped renewing its lease
+	Interruptions int64   `json:"interruptions"`  // Jobs the seller gave back while shutting down
+	Disputes      int64   `json:"disputes"`       // Results the buyer disputed
+	Disagreements int64   `json:"disagreements"`  // Results of verified jobs that disagreed with the majority
+	LatencySum    float64 `json:"latency_sum"`    // Seconds from claim to container start, summed over delivered jobs
+	LatencyCount  int64   `json:"latency_count"`  // Jobs in LatencySum
+	Score         float64 `json:"score"`          // Between 0 and 1, higher is better
+	UpdatedAt     int64   `json:"updated_at"`
+}
+
+// computeScore combines reliability and start latency into a score between
+// 0 and 1. Reliability is the share of good outcomes, with one good and one
+// bad outcome assumed up front so a new seller starts in the middle.
+// Disputes and disagreements count double; interruptions count half. Only
+// outcomes the seller caused count, so a buyer cancelling a job does not.
+func (r *Reputation) computeScore() float64 {
+	bad := float64(r.Faults+r.LeaseExpiries) + 2*float64(r.Disputes+r.Disagreements) + 0.5*float64(r.Interruptions)
+	reliability := (float64(r.Delivered) + 1) / (float64(r.Delivered) + bad + 2)
+
+	speed := NeutralReputation
+	if r.LatencyCount > 0 {
+		speed = 1 / (1 + r.LatencySum/float64(r.LatencyCount)/latencyHalfScore)
+	}
+	return math.Round(((1-latencyWeight)*reliability+latencyWeight*speed)*reputationScale) / reputationScale
+}
+
+// readReputation loads a seller's reputation and its storage version; a seller without one gets a fresh record
+func readReputation(ctx context.Context, nk runtime.NakamaModule, sellerID string) (*Reputation, string, error) {
+	objects, err := nk.StorageRead(ctx, []*runtime.StorageRead{{
+		Collection: reputationCollection,
+		Key:        sellerID,
+	}})
+	if err != nil {
+		return nil, "", err
+	}
+	if len(objects) == 0 {
+		return &Reputation{SellerID: sellerID, Score: NeutralReputation}, "*", nil
+	}
+	var rep Reputation
+	if err := json.Unmarshal([]byte(objects[0].Value), &rep); err != nil {
+		return nil, "", err
+	}
+	return &rep, objects[0].Version, nil
+}
+
+// updateReputation applies a change to a seller's reputation, recomputes its
+// score and publishes it to the leaderboard. Concurrent updates are retried.
+// Failures are logged rather than returned, since reputation never blocks a job.
+func updateReputation(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, sellerID string, change func(*Reputation)) {
+	if sellerID == "" {
+		return
+	}
+	for attempt := 1; ; attempt++ {
+		rep, version, err := readReputation(ctx, nk, sellerID)
+		if err != nil {
+			logger.Error("Failed to read reputation of seller %s: %v", sellerID, err)
+			return
+		}
+		change(rep)
+		rep.Score = rep.computeScore()
+		rep.UpdatedAt = time.Now().Unix()
+
+		err = writeReputation(ctx, nk, rep, version)
+		if errors.Is(err, runtime.ErrStorageRejectedVersion) && attempt < maxReputationRetries {
+			continue
+		}
+		if err != nil {
+			logger.Error("Failed to store reputation of seller %s: %v", sellerID, err)
+			return
+		}
+
+		if _, err := nk.LeaderboardRecordWrite(ctx, ReputationLeaderboard, sellerID, "", int64(math.Round(rep.Score*reputationScale)), 0, nil, nil); err != nil {
+			logger.Error("Failed to publish reputation of seller %s: %v", sellerID, err)
+		}
+		return
+	}
+}
+
+// writeReputation persists a reputation record if it still has the version it was read at
+func writeReputation(ctx context.Context, nk runtime.NakamaModule, rep *Reputation, version string) error {
+	value, err := json.Marshal(rep)
+	if err != nil {
+		return err
+	}
+	_, err = nk.StorageWrite(ctx, []*runtime.StorageWrite{{
+		Collection:      reputationCollection,
+		Key:             rep.SellerID,
+		Value:           string(value),
+		Version:         version,
+		PermissionRead:  0,
+		PermissionWrite: 0,
+	}})
+	return err
+}
+
+// recordResult updates the reputation of the seller that reported a job's result
+func recordResult(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, job *Job, result *JobResult) {
+	updateReputation(ctx, logger, nk, result.SellerID, func(r *Reputation) {
+		switch {
+		case result.Interrupted:
+			r.Interruptions++
+		case sellerAtFault(result):
+			r.Faults++
+		default:
+			r.Delivered++
+			if claimed := job.claimedAt(); claimed > 0 && result.Usage.StartedAt >= claimed {
+				r.LatencySum += float64(result.Usage.StartedAt - claimed)
+				r.LatencyCount++
+			}
+		}
+	})
+}
+
+// claimedAt returns when the current seller claimed the job, or zero
+func (j *Job) claimedAt() int64 {
+	for i := len(j.History) - 1; i >= 0; i-- {
+		if j.History[i].State == JobAssigned {
+			return j.History[i].At
+		}
+	}
+	return 0
+}
+
+// sellerReputations returns the reputation score of each seller, neutral for sellers without a record
+func sellerReputations(ctx context.Context, nk runtime.NakamaModule, sellerIDs []string) (map[string]float64, error) {
+	scores := make(map[string]float64, len(sellerIDs))
+	if len(sellerIDs) == 0 {
+		return scores, nil
+	}
+	reads := make([]*runtime.StorageRead, 0, len(sellerIDs))
+	for _, id := range sellerIDs {
+		scores[id] = NeutralReputation
+		reads = append(reads, &runtime.StorageRead{Collection: reputationCollection, Key: id})
+	}
+	objects, err := nk.StorageRead(ctx, reads)
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range objects {
+		var rep Reputation
+		if err := json.Unmarshal([]byte(obj.Value), &rep); err == nil {
+			scores[obj.Key] = rep.Score
+		}
+	}
+	return scores, nil
+}
+
+// withReputation keeps the sellers whose score is at least the minimum
+func withReputation(ctx context.Context, nk runtime.NakamaModule, sellers []*SellerRecord, minimum float64) ([]*SellerRecord, error) {
+	if minimum <= 0 || len(sellers) == 0 {
+		return sellers, nil
+	}
+	ids := make([]string, 0, len(sellers))
+	for _, s := range sellers {
+		ids = append(ids, s.UserID)
+	}
+	scores, err := sellerReputations(ctx, nk, ids)
+	if err != nil {
+		return nil, err
+	}
+	var trusted []*SellerRecord
+	for _, s := range sellers {
+		if scores[s.UserID] >= minimum {
+			trusted = append(trusted, s)
+		}
+	}
+	return trusted, nil
+}
+
+// GetSellerReputation returns the reputation record of a seller, the caller's own by default
+func GetSellerReputation(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req struct {
+		SellerID string `json:"seller_id"`
+	}
+	if payload != "" {
+		if err := json.Unmarshal([]byte(payload), &req); err != nil {
+			return "", errors.New("invalid get_seller_reputation request format")
+		}
+	}
+	if req.SellerID == "" {
+		req.SellerID = callerID(ctx)
+	}
+	if req.SellerID == "" {
+		return "", errNoSession
+	}
+
+	rep, _, err := readReputation(ctx, nk, req.SellerID)
+	if err != nil {
+		logger.Error("Failed to read reputation of seller %s: %v", req.SellerID, err)
+		return "", errors.New("failed to read reputation")
+	}
+	out, err := json.Marshal(rep)
+	if err != nil {
+		return "", errors.New("failed to encode reputation")
+	}
+	return string(out), nil
+}
+
+// Dispute records that the buyer contested a job's result
+type Dispute struct {
+	Reason string `json:"reason,omitempty"`
+	At     int64  `json:"at"`
+}
+
+// buyerDisputes records when a buyer filed its recent disputes
+type buyerDisputes struct {
+	BuyerID string  `json:"buyer_id"`
+	Filed   []int64 `json:"filed"` // When each dispute within the window was filed
+}
+
+// reserveDispute counts a new dispute against the buyer's limit, failing
+// once the buyer has filed maxDisputes within the window. The count is a
+// version-checked write, so concurrent disputes cannot exceed the limit.
+func reserveDispute(ctx context.Context, nk runtime.NakamaModule, buyerID string, now int64) error {
+	for attempt := 1; ; attempt++ {
+		objects, err := nk.StorageRead(ctx, []*runtime.StorageRead{{Collection: disputeCollection, Key: buyerID}})
+		if err != nil {
+			return err
+		}
+		record, version := buyerDisputes{BuyerID: buyerID}, "*"
+		if len(objects) > 0 {
+			if err := json.Unmarshal([]byte(objects[0].Value), &record); err != nil {
+				return err
+			}
+			version = objects[0].Version
+		}
+
+		var recent []int64
+		for _, at := range record.Filed {
+			if at > now-disputeWindowSeconds {
+				recent = append(recent, at)
+			}
+		}
+		if len(recent) >= maxDisputes {
+			return errDisputeLimit
+		}
+		record.Filed = append(recent, now)
+
+		value, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		_, err = nk.StorageWrite(ctx, []*runtime.StorageWrite{{
+			Collection:      disputeCollection,
+			Key:             buyerID,
+			Value:           string(value),
+			Version:         version,
+			PermissionRead:  0,
+			PermissionWrite: 0,
+		}})
+		if errors.Is(err, runtime.ErrStorageRejectedVersion) && attempt < maxReputationRetries {
+			continue
+		}
+		return err
+	}
+}
+
+// finishedAt returns when the job reached its terminal state, or zero
+func (j *Job) finishedAt() int64 {
+	if !j.State.Terminal() || len(j.History) == 0 {
+		return 0
+	}
+	return j.History[len(j.History)-1].At
+}
+
+// DisputeJob lets the buyer of a finished job dispute the seller's result
+// within disputeWindowSeconds of it finishing. Each job can be disputed once,
+// a buyer may file at most maxDisputes per window, and the dispute counts
+// against the seller's reputation.
+func DisputeJob(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req struct {
+		JobID  string `json:"job_id"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(payload), &req); err != nil || req.JobID == "" {
+		return "", errors.New("dispute_job requires a job_id")
+	}
+
+	userID := callerID(ctx)
+	if userID == "" {
+		return "", errNoSession
+	}
+
+	job, version, err := readJob(ctx, nk, req.JobID)
+	if err == errJobNotFound {
+		return "", err
+	}
+	if err != nil {
+		logger.Error("Failed to read job %s: %v", req.JobID, err)
+		return "", errors.New("failed to dispute job")
+	}
+	if job.Request.BuyerID != userID {
+		return "", errors.New("only the buyer of a job may dispute it")
+	}
+	if job.State != JobSucceeded && job.State != JobFailed {
+		return "", fmt.Errorf("job is %s; only delivered results can be disputed", job.State)
+	}
+	if job.Dispute != nil {
+		return "", errors.New("job has already been disputed")
+	}
+	now := time.Now().Unix()
+	if now > job.finishedAt()+disputeWindowSeconds {
+		return "", fmt.Errorf("results can only be disputed within %d hours of the job finishing", disputeWindowSeconds/3600)
+	}
+	if err := reserveDispute(ctx, nk, userID, now); err == errDisputeLimit {
+		return "", err
+	} else if err != nil {
+		logger.Error("Failed to count dispute of buyer %s: %v", userID, err)
+		return "", errors.New("failed to dispute job")
+	}
+
+	job.Dispute = &Dispute{Reason: req.Reason, At: now}
+	if err := writeJob(ctx, nk, job, version); err == errJobConflict {
+		return "", err
+	} else if err != nil {
+		logger.Error("Failed to store dispute of job %s: %v", req.JobID, err)
+		return "", errors.New("failed to dispute job")
+	}
+
+	// A verified job's result comes from one of its replicas, so blame the seller that delivered it
+	sellerID := job.Result.SellerID
+	updateReputation(ctx, logger, nk, sellerID, func(r *Reputation) { r.Disputes++ })
+	logger.Warn("Buyer %s disputed the result of job %s by seller %s", userID, req.JobID, sellerID)
+	return marshalJobState(job)
+}
@@ -0,0 +1,116 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestComputeScore(t *testing.T) {
+	tests := []struct {
+		name string
+		rep  Reputation
+		want float64
+	}{
+		{"new seller", Reputation{}, 0.5},
+		{"reliable", Reputation{Delivered: 8}, 0.82},
+		{"reliable and fast", Reputation{Delivered: 8, LatencyCount: 8}, 0.92},
+		{"reliable and slow", Reputation{Delivered: 8, LatencySum: 8 * 180, LatencyCount: 8}, 0.77},
+		{"faults", Reputation{Faults: 8}, 0.18},
+		{"lease expiries count like faults", Reputation{LeaseExpiries: 8}, 0.18},
+		{"disputes count double", Reputation{Delivered: 8, Disputes: 1}, 0.7},
+		{"disagreements count double", Reputation{Delivered: 8, Disagreements: 1}, 0.7},
+		{"interruptions count half", Reputation{Delivered: 8, Interruptions: 2}, 0.7545},
+	}
+	for _, tt := range tests {
+		got := tt.rep.computeScore()
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: score %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// However bad or good the record, the score stays between 0 and 1
+	worst := Reputation{Faults: 1e6, Disputes: 1e6, LatencySum: 1e9, LatencyCount: 1}
+	best := Reputation{Delivered: 1e6, LatencyCount: 1e6}
+	if s := worst.computeScore(); s < 0 || s > 0.01 {
+		t.Errorf("worst record scores %v", s)
+	}
+	if s := best.computeScore(); s > 1 || s < 0.99 {
+		t.Errorf("best record scores %v", s)
+	}
+}
+
+// storeFinishedJob stores a job that succeeded the given time ago
+func storeFinishedJob(t *testing.T, nk *fakeNakama, jobID string, ago time.Duration) {
+	t.Helper()
+	finished := time.Now().Add(-ago).Unix()
+	job := &Job{
+		Request:  JobRequest{JobID: jobID, BuyerID: "buyer-1"},
+		State:    JobSucceeded,
+		SellerID: "seller-1",
+		Result:   &JobResult{JobID: jobID, SellerID: "seller-1"},
+		History: []JobTransition{
+			{State: JobQueued, At: finished - 60},
+			{State: JobSucceeded, At: finished},
+		},
+	}
+	if err := createJob(context.Background(), nk, job); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// dispute calls dispute_job as the user
+func dispute(nk *fakeNakama, userID, jobID string) error {
+	_, err := DisputeJob(asUser(userID), fakeLogger{}, nil, nk, fmt.Sprintf(`{"job_id": %q, "reason": "wrong output"}`, jobID))
+	return err
+}
+
+func TestDisputeWindow(t *testing.T) {
+	nk := newFakeNakama()
+	storeFinishedJob(t, nk, "recent", time.Hour)
+	storeFinishedJob(t, nk, "old", disputeWindowSeconds*time.Second+time.Minute)
+
+	if err := dispute(nk, "buyer-2", "recent"); err == nil {
+		t.Error("want a dispute by another user refused")
+	}
+	if err := dispute(nk, "buyer-1", "old"); err == nil {
+		t.Error("want a dispute after the window refused")
+	}
+	if err := dispute(nk, "buyer-1", "recent"); err != nil {
+		t.Fatalf("want a dispute within the window accepted, got %v", err)
+	}
+	if err := dispute(nk, "buyer-1", "recent"); err == nil {
+		t.Error("want a second dispute of the same job refused")
+	}
+
+	rep, _, err := readReputation(context.Background(), nk, "seller-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Disputes != 1 {
+		t.Errorf("want one dispute counted against the seller, got %d", rep.Disputes)
+	}
+}
+
+func TestDisputeLimitPerBuyer(t *testing.T) {
+	nk := newFakeNakama()
+	for i := 0; i <= maxDisputes; i++ {
+		storeFinishedJob(t, nk, fmt.Sprintf("job-%d", i), time.Minute)
+	}
+	for i := 0; i < maxDisputes; i++ {
+		if err := dispute(nk, "buyer-1", fmt.Sprintf("job-%d", i)); err != nil {
+			t.Fatalf("dispute %d: %v", i+1, err)
+		}
+	}
+	if err := dispute(nk, "buyer-1", fmt.Sprintf("job-%d", maxDisputes)); err != errDisputeLimit {
+		t.Errorf("want the dispute past the limit refused, got %v", err)
+	}
+
+	// Disputes filed before the window no longer count
+	now := time.Now().Unix()
+	if err := reserveDispute(context.Background(), nk, "buyer-1", now+disputeWindowSeconds+1); err != nil {
+		t.Errorf("want disputes older than the window forgotten, got %v", err)
+	}
+}
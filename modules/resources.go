@@ -0,0 +1,73 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Resources describes the compute a job asks for, or the most a seller offers
+type Resources struct {
+	CPUs           float64 `json:"cpus,omitempty"`            // CPU cores
+	MemoryMB       int64   `json:"memory_mb,omitempty"`       // Memory limit in MiB
+	PidsLimit      int64   `json:"pids_limit,omitempty"`      // Maximum number of processes
+	DiskMB         int64   `json:"disk_mb,omitempty"`         // Size of the writable scratch space in MiB
+	TimeoutSeconds int64   `json:"timeout_seconds,omitempty"` // Wall-clock limit for the job
+}
+
+// DefaultResources fill in whatever a job request leaves unset
+var DefaultResources = Resources{
+	CPUs:           1,
+	MemoryMB:       512,
+	PidsLimit:      256,
+	DiskMB:         1024,
+	TimeoutSeconds: 600,
+}
+
+// WithDefaults returns the resources with every unset field taken from DefaultResources
+func (r Resources) WithDefaults() Resources {
+	if r.CPUs == 0 {
+		r.CPUs = DefaultResources.CPUs
+	}
+	if r.MemoryMB == 0 {
+		r.MemoryMB = DefaultResources.MemoryMB
+	}
+	if r.PidsLimit == 0 {
+		r.PidsLimit = DefaultResources.PidsLimit
+	}
+	if r.DiskMB == 0 {
+		r.DiskMB = DefaultResources.DiskMB
+	}
+	if r.TimeoutSeconds == 0 {
+		r.TimeoutSeconds = DefaultResources.TimeoutSeconds
+	}
+	return r
+}
+
+// Validate rejects negative resource values
+func (r Resources) Validate() error {
+	if r.CPUs < 0 || r.MemoryMB < 0 || r.PidsLimit < 0 || r.DiskMB < 0 || r.TimeoutSeconds < 0 {
+		return errors.New("resources must not be negative")
+	}
+	return nil
+}
+
+// Exceeds lists every resource asked for beyond the given limits. A zero limit means unlimited.
+func (r Resources) Exceeds(limits Resources) []string {
+	var over []string
+	if limits.CPUs > 0 && r.CPUs > limits.CPUs {
+		over = append(over, fmt.Sprintf("cpus %g > %g", r.CPUs, limits.CPUs))
+	}
+	if limits.MemoryMB > 0 && r.MemoryMB > limits.MemoryMB {
+		over = append(over, fmt.Sprintf("memory_mb %d > %d", r.MemoryMB, limits.MemoryMB))
+	}
+	if limits.PidsLimit > 0 && r.PidsLimit > limits.PidsLimit {
+		over = append(over, fmt.Sprintf("pids_limit %d > %d", r.PidsLimit, limits.PidsLimit))
+	}
+	if limits.DiskMB > 0 && r.DiskMB > limits.DiskMB {
+		over = append(over, fmt.Sprintf("disk_mb %d > %d", r.DiskMB, limits.DiskMB))
+	}
+	if limits.TimeoutSeconds > 0 && r.TimeoutSeconds > limits.TimeoutSeconds {
+		over = append(over, fmt.Sprintf("timeout_seconds %d > %d", r.TimeoutSeconds, limits.TimeoutSeconds))
+	}
+	return over
+}
@@ -0,0 +1,23 @@
+package modules
+
+// SandboxProfile is the container hardening a seller applies to every job.
+// The profile used is recorded in the JobResult.
+type SandboxProfile struct {
+	Name            string   `json:"name"`                        // Profile name, e.g. "default" or "hardened"
+	ReadOnlyRootfs  bool     `json:"read_only_rootfs,omitempty"`  // Mount the image read-only
+	TmpMB           int64    `json:"tmp_mb,omitempty"`            // Size of a writable tmpfs at /tmp, zero for none
+	CapDrop         []string `json:"cap_drop,omitempty"`          // Linux capabilities removed, e.g. "ALL"
+	CapAdd          []string `json:"cap_add,omitempty"`           // Linux capabilities given back after dropping
+	NoNewPrivileges bool     `json:"no_new_privileges,omitempty"` // Block setuid binaries from gaining privileges
+	User            string   `json:"user,omitempty"`              // user[:group] the command runs as
+	PidsLimit       int64    `json:"pids_limit,omitempty"`        // Ceiling on the job's pids_limit
+	Ulimits         []Ulimit `json:"ulimits,omitempty"`           // Resource limits set with setrlimit
+	SeccompProfile  string   `json:"seccomp_profile,omitempty"`   // Path to a seccomp profile on the seller host
+}
+
+// Ulimit is a setrlimit limit applied inside the container
+type Ulimit struct {
+	Name string `json:"name"` // e.g. "nofile", "nproc", "core"
+	Soft int64  `json:"soft"`
+	Hard int64  `json:"hard"`
+}
@@ -0,0 +1,372 @@
+package modules
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/bdr-pro/lumaris/imageref"
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	// sellerCollection holds one system-owned storage object per registered seller
+	sellerCollection = "sellers"
+
+	// DefaultSellerTTL is how long (in seconds) a seller stays online without a heartbeat
+	DefaultSellerTTL = 60
+	// maxSellerTTL caps the TTL a seller may ask for
+	maxSellerTTL = 600
+)
+
+var errSellerNotRegistered = errors.New("seller is not registered")
+
+// SellerRecord is the persisted state of a registered seller
+type SellerRecord struct {
+	UserID        string     `json:"user_id"`                  // Nakama user ID of the seller
+	Capabilities  []string   `json:"capabilities"`             // Image patterns the seller is able to run
+	DeniedImages  []string   `json:"denied_images,omitempty"`  // Image patterns the seller refuses even if capable
+	RequireDigest bool       `json:"require_digest,omitempty"` // The seller only runs images pinned by digest
+	Labels        []string   `json:"labels"`                   // Host properties matched against job requirements
+	Limits        Resources  `json:"limits"`                   // Most resources the seller gives a single job
+	Slots         int        `json:"slots,omitempty"`          // Jobs the seller runs at once, zero if it does not say
+	FreeSlots     int        `json:"free_slots"`               // Slots not taken by running or locally queued jobs
+	Pricing       *PriceCard `json:"pricing,omitempty"`        // What the seller charges, nil if it does not say
+	Draining      bool       `json:"draining,omitempty"`       // The seller is shutting down and takes no new jobs
+	RegisteredAt  int64      `json:"registered_at"`            // When the seller first registered
+	LastHeartbeat int64      `json:"last_heartbeat"`           // When the seller was last heard from
+	TTL           int64      `json:"ttl"`                      // Seconds the seller stays online after a heartbeat
+}
+
+// Online reports whether the seller has sent a heartbeat within its TTL
+func (s *SellerRecord) Online(now int64) bool {
+	return now-s.LastHeartbeat <= s.TTL
+}
+
+// AcceptsImage reports whether the seller's image policy lets it run the image
+func (s *SellerRecord) AcceptsImage(image string) bool {
+	if !canRunImage(s.Capabilities, image) {
+		return false
+	}
+	for _, pattern := range s.DeniedImages {
+		if imageref.Match(pattern, image) {
+			return false
+		}
+	}
+	if s.RequireDigest {
+		ref, err := imageref.Parse(image)
+		return err == nil && ref.Digest != ""
+	}
+	return true
+}
+
+// HasFreeSlot reports whether the seller can take another job right now
+func (s *SellerRecord) HasFreeSlot() bool {
+	return s.Slots == 0 || s.FreeSlots > 0
+}
+
+// ExpiresAt returns the time at which the seller goes offline unless it sends a heartbeat
+func (s *SellerRecord) ExpiresAt() int64 {
+	return s.LastHeartbeat + s.TTL
+}
+
+// RegisterSeller stores the seller and its capabilities in the registry
+func RegisterSeller(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var seller struct {
+		UserID        string     `json:"user_id"`
+		Capabilities  []string   `json:"capabilities"`
+		DeniedImages  []string   `json:"denied_images"`
+		RequireDigest bool       `json:"require_digest"`
+		Labels        []string   `json:"labels"`
+		Limits        Resources  `json:"limits"`
+		Slots         int        `json:"slots"`
+		FreeSlots     *int       `json:"free_slots"`
+		Pricing       *PriceCard `json:"pricing"`
+		TTL           int64      `json:"ttl"`
+	}
+	if err := json.Unmarshal([]byte(payload), &seller); err != nil {
+		logger.Error("Failed to parse seller registration: %v", err)
+		return "", errors.New("invalid seller registration format")
+	}
+
+	// Sellers are keyed by the authenticated user
+	userID, err := sessionUserID(ctx, seller.UserID)
+	if err != nil {
+		return "", err
+	}
+	seller.UserID = userID
+	if len(seller.Capabilities) == 0 {
+		return "", errors.New("seller registration must include capabilities")
+	}
+	for _, pattern := range seller.DeniedImages {
+		if err := imageref.ValidPattern(pattern); err != nil {
+			return "", err
+		}
+	}
+	if err := seller.Limits.Validate(); err != nil {
+		return "", err
+	}
+	if err := seller.Pricing.Validate(); err != nil {
+		return "", err
+	}
+	if seller.Slots < 0 {
+		return "", errors.New("slots must not be negative")
+	}
+	freeSlots := seller.Slots
+	if seller.FreeSlots != nil {
+		if *seller.FreeSlots < 0 || *seller.FreeSlots > seller.Slots {
+			return "", errors.New("free_slots must be between 0 and slots")
+		}
+		freeSlots = *seller.FreeSlots
+	}
+
+	switch {
+	case seller.TTL <= 0:
+		seller.TTL = DefaultSellerTTL
+	case seller.TTL > maxSellerTTL:
+		seller.TTL = maxSellerTTL
+	}
+
+	now := time.Now().Unix()
+	record := &SellerRecord{
+		UserID:        seller.UserID,
+		Capabilities:  seller.Capabilities,
+		DeniedImages:  seller.DeniedImages,
+		RequireDigest: seller.RequireDigest,
+		Labels:        seller.Labels,
+		Limits:        seller.Limits,
+		Slots:         seller.Slots,
+		FreeSlots:     freeSlots,
+		Pricing:       seller.Pricing,
+		RegisteredAt:  now,
+		LastHeartbeat: now,
+		TTL:           seller.TTL,
+	}
+
+	// Keep the original registration time when a seller re-registers
+	if existing, err := readSeller(ctx, nk, seller.UserID); err == nil {
+		record.RegisteredAt = existing.RegisteredAt
+	} else if err != errSellerNotRegistered {
+		logger.Error("Failed to read seller %s: %v", seller.UserID, err)
+		return "", errors.New("failed to register seller")
+	}
+
+	if err := writeSeller(ctx, nk, record); err != nil {
+		logger.Error("Failed to store seller %s: %v", seller.UserID, err)
+		return "", errors.New("failed to register seller")
+	}
+
+	logger.Info("Seller registered: %s with capabilities: %v", record.UserID, record.Capabilities)
+	return marshalSellerStatus(record)
+}
+
+// SellerHeartbeat refreshes the last-heartbeat time of a registered seller,
+// along with its free slots when it sends them. A heartbeat with draining
+// set stops the seller from being offered jobs until it registers again.
+func SellerHeartbeat(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req struct {
+		FreeSlots *int `json:"free_slots"`
+		Draining  bool `json:"draining"`
+	}
+	if payload != "" {
+		if err := json.Unmarshal([]byte(payload), &req); err != nil {
+			return "", errors.New("invalid seller_heartbeat request format")
+		}
+	}
+
+	userID := callerID(ctx)
+	if userID == "" {
+		return "", errNoSession
+	}
+
+	record, err := readSeller(ctx, nk, userID)
+	if err == errSellerNotRegistered {
+		return "", err
+	}
+	if err != nil {
+		logger.Error("Failed to read seller %s: %v", userID, err)
+		return "", errors.New("failed to record heartbeat")
+	}
+
+	if req.FreeSlots != nil {
+		if *req.FreeSlots < 0 || *req.FreeSlots > record.Slots {
+			return "", errors.New("free_slots must be between 0 and slots")
+		}
+		record.FreeSlots = *req.FreeSlots
+	}
+	if req.Draining && !record.Draining {
+		logger.Info("Seller %s is draining", userID)
+		record.Draining = true
+	}
+	record.LastHeartbeat = time.Now().Unix()
+	if err := writeSeller(ctx, nk, record); err != nil {
+		logger.Error("Failed to store heartbeat for seller %s: %v", userID, err)
+		return "", errors.New("failed to record heartbeat")
+	}
+
+	return marshalSellerStatus(record)
+}
+
+// DeregisterSeller removes the calling seller from the registry. Jobs it
+// still holds a lease on are requeued by the sweeper once the lease runs out.
+func DeregisterSeller(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req struct {
+		UserID string `json:"user_id"`
+	}
+	if payload != "" {
+		if err := json.Unmarshal([]byte(payload), &req); err != nil {
+			return "", errors.New("invalid deregister_seller request format")
+		}
+	}
+	userID, err := sessionUserID(ctx, req.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := readSeller(ctx, nk, userID); err == errSellerNotRegistered {
+		return "", err
+	} else if err != nil {
+		logger.Error("Failed to read seller %s: %v", userID, err)
+		return "", errors.New("failed to deregister seller")
+	}
+
+	if err := nk.StorageDelete(ctx, []*runtime.StorageDelete{{
+		Collection: sellerCollection,
+		Key:        userID,
+	}}); err != nil {
+		logger.Error("Failed to delete seller %s: %v", userID, err)
+		return "", errors.New("failed to deregister seller")
+	}
+
+	logger.Info("Seller deregistered: %s", userID)
+	return `{"status":"seller_deregistered"}`, nil
+}
+
+// ListSellers returns the registered sellers, only the online ones unless include_offline is set
+func ListSellers(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req struct {
+		IncludeOffline bool `json:"include_offline"`
+	}
+	if payload != "" {
+		if err := json.Unmarshal([]byte(payload), &req); err != nil {
+			return "", errors.New("invalid list_sellers request format")
+		}
+	}
+
+	sellers, err := listSellers(ctx, nk)
+	if err != nil {
+		logger.Error("Failed to list sellers: %v", err)
+		return "", errors.New("failed to list sellers")
+	}
+
+	type sellerEntry struct {
+		*SellerRecord
+		Online     bool    `json:"online"`
+		ExpiresAt  int64   `json:"expires_at"`
+		Reputation float64 `json:"reputation"`
+	}
+
+	now := time.Now().Unix()
+	var listed []*SellerRecord
+	ids := make([]string, 0, len(sellers))
+	for _, s := range sellers {
+		if !s.Online(now) && !req.IncludeOffline {
+			continue
+		}
+		listed = append(listed, s)
+		ids = append(ids, s.UserID)
+	}
+
+	scores, err := sellerReputations(ctx, nk, ids)
+	if err != nil {
+		logger.Error("Failed to read seller reputations: %v", err)
+		return "", errors.New("failed to list sellers")
+	}
+
+	entries := make([]sellerEntry, 0, len(listed))
+	for _, s := range listed {
+		entries = append(entries, sellerEntry{SellerRecord: s, Online: s.Online(now), ExpiresAt: s.ExpiresAt(), Reputation: scores[s.UserID]})
+	}
+
+	out, err := json.Marshal(map[string]interface{}{"sellers": entries})
+	if err != nil {
+		return "", errors.New("failed to encode seller list")
+	}
+	return string(out), nil
+}
+
+// marshalSellerStatus builds the response returned to a seller after registration or heartbeat
+func marshalSellerStatus(record *SellerRecord) (string, error) {
+	out, err := json.Marshal(map[string]interface{}{
+		"status":     "seller_registered",
+		"user_id":    record.UserID,
+		"ttl":        record.TTL,
+		"expires_at": record.ExpiresAt(),
+	})
+	if err != nil {
+		return "", errors.New("failed to encode seller status")
+	}
+	return string(out), nil
+}
+
+// readSeller loads a seller record from storage
+func readSeller(ctx context.Context, nk runtime.NakamaModule, userID string) (*SellerRecord, error) {
+	objects, err := nk.StorageRead(ctx, []*runtime.StorageRead{{
+		Collection: sellerCollection,
+		Key:        userID,
+	}})
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 {
+		return nil, errSellerNotRegistered
+	}
+
+	var record SellerRecord
+	if err := json.Unmarshal([]byte(objects[0].Value), &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// writeSeller persists a seller record; only the server may read or write it
+func writeSeller(ctx context.Context, nk runtime.NakamaModule, record *SellerRecord) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	_, err = nk.StorageWrite(ctx, []*runtime.StorageWrite{{
+		Collection:      sellerCollection,
+		Key:             record.UserID,
+		Value:           string(value),
+		PermissionRead:  0,
+		PermissionWrite: 0,
+	}})
+	return err
+}
+
+// listSellers loads every seller record in the registry
+func listSellers(ctx context.Context, nk runtime.NakamaModule) ([]*SellerRecord, error) {
+	var sellers []*SellerRecord
+	cursor := ""
+	for {
+		objects, next, err := nk.StorageList(ctx, "", "", sellerCollection, 100, cursor)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objects {
+			var record SellerRecord
+			if err := json.Unmarshal([]byte(obj.Value), &record); err != nil {
+				continue
+			}
+			sellers = append(sellers, &record)
+		}
+		if next == "" {
+			return sellers, nil
+		}
+		cursor = next
+	}
+}
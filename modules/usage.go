@@ -0,0 +1,56 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+)
+
+// usageSlackSeconds allows for timing jitter and for the seller's clock
+// being slightly behind the server's when usage is checked against the job
+const usageSlackSeconds = 5
+
+// Usage is what a job consumed on the seller, measured while it ran
+type Usage struct {
+	StartedAt        int64   `json:"started_at"`         // When the container started, unix seconds
+	FinishedAt       int64   `json:"finished_at"`        // When the container exited, unix seconds
+	WallSeconds      float64 `json:"wall_seconds"`       // Time the container ran
+	CPUSeconds       float64 `json:"cpu_seconds"`        // CPU time used by the container
+	PeakMemoryBytes  uint64  `json:"peak_memory_bytes"`  // Highest memory use seen
+	ImagePullSeconds float64 `json:"image_pull_seconds"` // Time spent making the image available
+	OutputBytes      int64   `json:"output_bytes"`       // Bytes written to stdout and stderr
+	ArtifactBytes    int64   `json:"artifact_bytes"`     // Size of the compressed artifact bundle
+	Samples          int     `json:"samples"`            // Stats samples the CPU and memory figures come from
+}
+
+// Validate rejects usage records that cannot be right
+func (u *Usage) Validate() error {
+	if u == nil {
+		return nil
+	}
+	if u.WallSeconds < 0 || u.CPUSeconds < 0 || u.ImagePullSeconds < 0 || u.OutputBytes < 0 || u.ArtifactBytes < 0 || u.Samples < 0 {
+		return errors.New("usage values must not be negative")
+	}
+	if u.FinishedAt < u.StartedAt {
+		return errors.New("usage finishes before it starts")
+	}
+	return nil
+}
+
+// Within rejects usage that a job with the given resources, claimed at the
+// given time, cannot have used: a start before the claim, a run longer than
+// the timeout, or more CPU time than its CPUs give over the wall time
+func (u *Usage) Within(r Resources, claimedAt int64) error {
+	if u == nil || u.StartedAt == 0 {
+		return nil
+	}
+	if claimedAt > 0 && u.StartedAt < claimedAt-usageSlackSeconds {
+		return errors.New("usage starts before the job was claimed")
+	}
+	if r.TimeoutSeconds > 0 && u.WallSeconds > float64(r.TimeoutSeconds+usageSlackSeconds) {
+		return fmt.Errorf("usage wall time of %gs is longer than the job's timeout of %ds", u.WallSeconds, r.TimeoutSeconds)
+	}
+	if r.CPUs > 0 && u.CPUSeconds > r.CPUs*(u.WallSeconds+usageSlackSeconds) {
+		return fmt.Errorf("usage of %g CPU-seconds is more than %g CPUs give in %gs", u.CPUSeconds, r.CPUs, u.WallSeconds)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package modules
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUsageWithin(t *testing.T) {
+	resources := Resources{CPUs: 2, TimeoutSeconds: 60}
+	const claimed = 1000
+	tests := []struct {
+		name   string
+		usage  *Usage
+		reject string // Part of the error, empty when the usage is accepted
+	}{
+		{"no usage", nil, ""},
+		{"never started", &Usage{CPUSeconds: 500}, ""},
+		{"full use", &Usage{StartedAt: 1001, FinishedAt: 1061, WallSeconds: 60, CPUSeconds: 120}, ""},
+		{"clock slightly behind", &Usage{StartedAt: 997, FinishedAt: 1007, WallSeconds: 10, CPUSeconds: 5}, ""},
+		{"starts before claim", &Usage{StartedAt: 900, FinishedAt: 910, WallSeconds: 10, CPUSeconds: 5}, "before the job was claimed"},
+		{"runs past timeout", &Usage{StartedAt: 1001, FinishedAt: 2001, WallSeconds: 1000, CPUSeconds: 5}, "longer than the job's timeout"},
+		{"more CPU than cores", &Usage{StartedAt: 1001, FinishedAt: 1011, WallSeconds: 10, CPUSeconds: 100}, "CPU-seconds"},
+	}
+	for _, tt := range tests {
+		err := tt.usage.Within(resources, claimed)
+		switch {
+		case tt.reject == "" && err != nil:
+			t.Errorf("%s: got %v, want the usage accepted", tt.name, err)
+		case tt.reject != "" && (err == nil || !strings.Contains(err.Error(), tt.reject)):
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.reject)
+		}
+	}
+}
+
+func TestUsageValidate(t *testing.T) {
+	if err := (&Usage{StartedAt: 10, FinishedAt: 20, WallSeconds: 10}).Validate(); err != nil {
+		t.Errorf("want valid usage accepted, got %v", err)
+	}
+	if err := (&Usage{CPUSeconds: -1}).Validate(); err == nil {
+		t.Error("want negative usage refused")
+	}
+	if err := (&Usage{StartedAt: 20, FinishedAt: 10}).Validate(); err == nil {
+		t.Error("want usage finishing before it starts refused")
+	}
+}
@@ -0,0 +1,155 @@
+package realtime
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// minBackoff and maxBackoff bound the delay between reconnect attempts
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+
+	// readTimeout drops connections that stay silent longer than the server's ping period
+	readTimeout = 60 * time.Second
+)
+
+// Notification is a Nakama notification received over the realtime socket
+type Notification struct {
+	ID         string `json:"id"`
+	Subject    string `json:"subject"`
+	Content    string `json:"content"` // JSON-encoded notification body
+	Code       int    `json:"code"`
+	SenderID   string `json:"sender_id"`
+	Persistent bool   `json:"persistent"`
+}
+
+// envelope is the subset of the Nakama realtime envelope the clients read
+type envelope struct {
+	Notifications *struct {
+		Notifications []Notification `json:"notifications"`
+	} `json:"notifications"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Socket is a Nakama realtime connection that reconnects with exponential
+// backoff whenever it drops
+type Socket struct {
+	server string
+	token  string
+
+	// TLS, when set, connects with wss:// using this configuration
+	TLS *tls.Config
+
+	// OnNotification is called for every notification received
+	OnNotification func(Notification)
+	// OnConnect is called after every successful (re)connect
+	OnConnect func()
+}
+
+// NewSocket creates a socket for the given server address and session token
+func NewSocket(server, token string) *Socket {
+	return &Socket{server: server, token: token}
+}
+
+// Run keeps the socket connected until the context is cancelled
+func (s *Socket) Run(ctx context.Context) error {
+	backoff := minBackoff
+	for {
+		err := s.connectAndRead(ctx, func() { backoff = minBackoff })
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// Add jitter so a fleet of clients does not reconnect in lockstep
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+		log.Printf("Realtime socket disconnected: %v (reconnecting in %s)", err, delay.Round(time.Millisecond))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// connectAndRead dials the server and dispatches messages until the connection fails
+func (s *Socket) connectAndRead(ctx context.Context, connected func()) error {
+	q := url.Values{}
+	q.Set("token", s.token)
+	q.Set("format", "json")
+	q.Set("status", "true")
+	u := url.URL{Scheme: "ws", Host: s.server, Path: "/ws", RawQuery: q.Encode()}
+	dialer := *websocket.DefaultDialer
+	if s.TLS != nil {
+		u.Scheme = "wss"
+		dialer.TLSClientConfig = s.TLS
+	}
+
+	conn, _, err := dialer.DialContext(ctx, u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("dial failed: %w", err)
+	}
+	defer conn.Close()
+
+	// Close the connection when the context ends so ReadMessage unblocks
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	// The server pings periodically; each ping proves the connection is still alive
+	conn.SetReadDeadline(time.Now().Add(readTimeout))
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(5*time.Second))
+	})
+
+	connected()
+	log.Println("Realtime socket connected.")
+	if s.OnConnect != nil {
+		s.OnConnect()
+	}
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+
+		var env envelope
+		if err := json.Unmarshal(data, &env); err != nil {
+			log.Printf("Ignoring malformed realtime message: %v", err)
+			continue
+		}
+		if env.Error != nil {
+			log.Printf("Realtime error [%d]: %s", env.Error.Code, env.Error.Message)
+		}
+		if env.Notifications != nil && s.OnNotification != nil {
+			for _, n := range env.Notifications.Notifications {
+				s.OnNotification(n)
+			}
+		}
+	}
+}
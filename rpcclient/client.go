@@ -0,0 +1,74 @@
+package rpcclient
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Client calls Nakama RPCs over the server's REST API with a session token
+type Client struct {
+	server string
+	token  string
+	http   *http.Client
+	scheme string
+}
+
+// New creates a client for the given server address and session token. A
+// non-nil TLS configuration makes it reach the server over https.
+func New(server, token string, tlsConfig *tls.Config) *Client {
+	c := &Client{server: server, token: token, http: http.DefaultClient, scheme: "http"}
+	if tlsConfig != nil {
+		c.http = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		c.scheme = "https"
+	}
+	return c
+}
+
+// Call posts a JSON payload to a Nakama RPC and returns the raw response payload
+func (c *Client) Call(rpc string, payload interface{}) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	// unwrap lets Nakama pass the JSON body to the RPC as-is and return its result unwrapped
+	url := fmt.Sprintf("%s://%s/v2/rpc/%s?unwrap", c.scheme, c.server, rpc)
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &Error{RPC: rpc, Status: resp.StatusCode, Body: string(body)}
+	}
+
+	return body, nil
+}
+
+// Error is returned when the server rejects an RPC, as opposed to the request not reaching it
+type Error struct {
+	RPC    string
+	Status int
+	Body   string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s rejected [%d]: %s", e.RPC, e.Status, e.Body)
+}
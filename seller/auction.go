@@ -0,0 +1,50 @@
+package seller
+
+import (
+	"log"
+	"strings"
+
+	"github.com/bdr-pro/lumaris/modules"
+)
+
+// bidOnJob answers an auction announcement. The runner bids the most its
+// price card could charge for the job, and estimates the job takes its
+// whole timeout, twice that when it would have to wait for a free slot.
+// Jobs the seller would refuse as an offer get no bid.
+func (r *Runner) bidOnJob(a modules.AuctionAnnouncement) {
+	job := a.Job
+	job.Resources = job.Resources.WithDefaults()
+	if r.draining.Load() {
+		log.Printf("Not bidding on job %s: seller is draining", job.JobID)
+		return
+	}
+	if over := job.Resources.Exceeds(r.limits()); len(over) > 0 {
+		log.Printf("Not bidding on job %s: exceeds seller limits (%s)", job.JobID, strings.Join(over, ", "))
+		return
+	}
+	if err := r.Policy.Check(job.Image); err != nil {
+		log.Printf("Not bidding on job %s: %v", job.JobID, err)
+		return
+	}
+
+	price := r.Pricing.MaxCost(job.Resources)
+	if price > job.MaxPrice {
+		log.Printf("Not bidding on job %s: price %d is above its max price %d", job.JobID, price, job.MaxPrice)
+		return
+	}
+	estimate := job.Resources.TimeoutSeconds
+	if r.pool.free() == 0 {
+		estimate *= 2
+	}
+
+	if _, err := r.rpc("bid_job", map[string]interface{}{
+		"job_id":            job.JobID,
+		"seller_id":         r.SellerID,
+		"price":             price,
+		"estimated_seconds": estimate,
+	}); err != nil {
+		log.Printf("Failed to bid on job %s: %v", job.JobID, err)
+		return
+	}
+	log.Printf("Bid %d credits on job %s", price, job.JobID)
+}
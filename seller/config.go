@@ -0,0 +1,430 @@
+package seller
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bdr-pro/lumaris/modules"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is everything the seller runner can be configured with. It is read
+// from a YAML or JSON file, then overridden by LUMARIS_* environment
+// variables, then by command-line flags.
+type Config struct {
+	Identity    IdentityConfig `yaml:"identity" json:"identity"`
+	Server      ServerConfig   `yaml:"server" json:"server"`
+	Runtime     string         `yaml:"runtime" json:"runtime"`           // docker-cli or docker-engine
+	Slots       int            `yaml:"slots" json:"slots"`               // Jobs run at the same time
+	Queue       int            `yaml:"queue" json:"queue"`               // Offers kept waiting for a free slot, zero for the slot count
+	Limits      LimitsConfig   `yaml:"limits" json:"limits"`             // Caps on what a single job gets; unset fields are detected from the host
+	Output      OutputConfig   `yaml:"output" json:"output"`             // Output and artifact size limits
+	ImagePolicy *ImagePolicy   `yaml:"image_policy" json:"image_policy"` // Inline image policy
+	// ImagePolicyFile is a JSON image policy file, used when no inline policy is given
+	ImagePolicyFile string        `yaml:"image_policy_file" json:"image_policy_file"`
+	Sandbox         string        `yaml:"sandbox" json:"sandbox"` // default, hardened, or a JSON profile file
+	Pricing         PricingConfig `yaml:"pricing" json:"pricing"`
+	// DrainSeconds is how long running jobs get to finish on shutdown
+	DrainSeconds int `yaml:"drain_seconds" json:"drain_seconds"`
+}
+
+// IdentityConfig is how the seller authenticates; its user ID comes from the token
+type IdentityConfig struct {
+	Token     string `yaml:"token" json:"token"`           // Nakama session token
+	TokenFile string `yaml:"token_file" json:"token_file"` // File holding the session token, read when token is empty
+}
+
+// ServerConfig is where the Nakama server is and how to reach it
+type ServerConfig struct {
+	Address string    `yaml:"address" json:"address"` // host:port
+	TLS     TLSConfig `yaml:"tls" json:"tls"`
+}
+
+// TLSConfig enables https and wss towards the server
+type TLSConfig struct {
+	Enabled            bool   `yaml:"enabled" json:"enabled"`
+	CAFile             string `yaml:"ca_file" json:"ca_file"`                           // PEM bundle trusted instead of the system roots
+	ServerName         string `yaml:"server_name" json:"server_name"`                   // Name checked against the certificate, if not the address host
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" json:"insecure_skip_verify"` // Accept any certificate; for testing only
+}
+
+// LimitsConfig caps the resources given to a single job
+type LimitsConfig struct {
+	CPUs           float64 `yaml:"cpus" json:"cpus"`
+	MemoryMB       int64   `yaml:"memory_mb" json:"memory_mb"`
+	PidsLimit      int64   `yaml:"pids_limit" json:"pids_limit"`
+	DiskMB         int64   `yaml:"disk_mb" json:"disk_mb"`
+	TimeoutSeconds int64   `yaml:"timeout_seconds" json:"timeout_seconds"`
+}
+
+// OutputConfig bounds what the seller keeps and returns of a job's output
+type OutputConfig struct {
+	InlineBytes   int   `yaml:"inline_bytes" json:"inline_bytes"`     // Sent inline with the result, per stream
+	MaxBytes      int64 `yaml:"max_bytes" json:"max_bytes"`           // Kept at all, per stream
+	ArtifactBytes int64 `yaml:"artifact_bytes" json:"artifact_bytes"` // Files from /out returned per job
+}
+
+// PricingConfig is the seller's price card, in wallet credits
+type PricingConfig struct {
+	CPUSecond float64 `yaml:"cpu_second" json:"cpu_second"`
+	GBSecond  float64 `yaml:"gb_second" json:"gb_second"`
+	Minimum   float64 `yaml:"minimum" json:"minimum"`
+}
+
+// DefaultConfig returns the configuration used for anything left unset
+func DefaultConfig() *Config {
+	return &Config{
+		Server:  ServerConfig{Address: "127.0.0.1:7350"},
+		Runtime: "docker-cli",
+		Slots:   1,
+		Output: OutputConfig{
+			InlineBytes:   DefaultInlineOutput,
+			MaxBytes:      DefaultMaxOutput,
+			ArtifactBytes: DefaultMaxArtifacts,
+		},
+		Sandbox:      "default",
+		DrainSeconds: DefaultDrainSeconds,
+	}
+}
+
+// LoadConfig reads a config file over the defaults. Files ending in .json
+// are read as JSON, anything else as YAML. Unknown keys are errors.
+func LoadConfig(file string) (*Config, error) {
+	cfg := DefaultConfig()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.EqualFold(filepath.Ext(file), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(cfg)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err = dec.Decode(cfg); err != nil && len(bytes.TrimSpace(data)) == 0 {
+			// An empty file keeps the defaults
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", file, err)
+	}
+	return cfg, nil
+}
+
+// envOverrides maps each LUMARIS_* variable to the setting it overrides
+var envOverrides = []struct {
+	name  string
+	apply func(c *Config, v string) error
+}{
+	{"LUMARIS_SERVER", func(c *Config, v string) error { c.Server.Address = v; return nil }},
+	{"LUMARIS_TOKEN", func(c *Config, v string) error { c.Identity.Token = v; return nil }},
+	{"LUMARIS_TOKEN_FILE", func(c *Config, v string) error { c.Identity.TokenFile = v; return nil }},
+	{"LUMARIS_TLS", func(c *Config, v string) error { return parseBool(v, &c.Server.TLS.Enabled) }},
+	{"LUMARIS_TLS_CA_FILE", func(c *Config, v string) error { c.Server.TLS.CAFile = v; return nil }},
+	{"LUMARIS_TLS_SERVER_NAME", func(c *Config, v string) error { c.Server.TLS.ServerName = v; return nil }},
+	{"LUMARIS_TLS_INSECURE_SKIP_VERIFY", func(c *Config, v string) error { return parseBool(v, &c.Server.TLS.InsecureSkipVerify) }},
+	{"LUMARIS_RUNTIME", func(c *Config, v string) error { c.Runtime = v; return nil }},
+	{"LUMARIS_SLOTS", func(c *Config, v string) error { return parseInt(v, &c.Slots) }},
+	{"LUMARIS_QUEUE", func(c *Config, v string) error { return parseInt(v, &c.Queue) }},
+	{"LUMARIS_DRAIN_SECONDS", func(c *Config, v string) error { return parseInt(v, &c.DrainSeconds) }},
+	{"LUMARIS_CPUS", func(c *Config, v string) error { return parseFloat(v, &c.Limits.CPUs) }},
+	{"LUMARIS_MEMORY_MB", func(c *Config, v string) error { return parseInt64(v, &c.Limits.MemoryMB) }},
+	{"LUMARIS_PIDS_LIMIT", func(c *Config, v string) error { return parseInt64(v, &c.Limits.PidsLimit) }},
+	{"LUMARIS_DISK_MB", func(c *Config, v string) error { return parseInt64(v, &c.Limits.DiskMB) }},
+	{"LUMARIS_TIMEOUT_SECONDS", func(c *Config, v string) error { return parseInt64(v, &c.Limits.TimeoutSeconds) }},
+	{"LUMARIS_INLINE_OUTPUT", func(c *Config, v string) error { return parseInt(v, &c.Output.InlineBytes) }},
+	{"LUMARIS_MAX_OUTPUT", func(c *Config, v string) error { return parseInt64(v, &c.Output.MaxBytes) }},
+	{"LUMARIS_MAX_ARTIFACTS", func(c *Config, v string) error { return parseInt64(v, &c.Output.ArtifactBytes) }},
+	{"LUMARIS_IMAGE_POLICY_FILE", func(c *Config, v string) error { c.ImagePolicyFile, c.ImagePolicy = v, nil; return nil }},
+	{"LUMARIS_SANDBOX", func(c *Config, v string) error { c.Sandbox = v; return nil }},
+	{"LUMARIS_PRICE_CPU_SECOND", func(c *Config, v string) error { return parseFloat(v, &c.Pricing.CPUSecond) }},
+	{"LUMARIS_PRICE_GB_SECOND", func(c *Config, v string) error { return parseFloat(v, &c.Pricing.GBSecond) }},
+	{"LUMARIS_PRICE_MINIMUM", func(c *Config, v string) error { return parseFloat(v, &c.Pricing.Minimum) }},
+}
+
+// ApplyEnv overrides settings with the LUMARIS_* variables that are set
+func (c *Config) ApplyEnv() error {
+	var errs []error
+	for _, o := range envOverrides {
+		if v, ok := os.LookupEnv(o.name); ok {
+			if err := o.apply(c, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", o.name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func parseBool(v string, dst *bool) error {
+	b, err := strconv.ParseBool(v)
+	if err == nil {
+		*dst = b
+	}
+	return err
+}
+
+func parseInt(v string, dst *int) error {
+	n, err := strconv.Atoi(v)
+	if err == nil {
+		*dst = n
+	}
+	return err
+}
+
+func parseInt64(v string, dst *int64) error {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err == nil {
+		*dst = n
+	}
+	return err
+}
+
+func parseFloat(v string, dst *float64) error {
+	f, err := strconv.ParseFloat(v, 64)
+	if err == nil {
+		*dst = f
+	}
+	return err
+}
+
+// Validate returns every problem with the configuration
+func (c *Config) Validate() []error {
+	var errs []error
+	add := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if _, err := c.SessionToken(); err != nil {
+		add(err)
+	}
+	if c.Server.Address == "" {
+		add(errors.New("server.address is required"))
+	}
+	if _, err := c.TLSConfig(); err != nil {
+		add(err)
+	}
+	if _, err := NewRuntime(c.Runtime); err != nil {
+		add(err)
+	}
+	if c.Slots < 1 {
+		add(errors.New("slots must be at least 1"))
+	}
+	if c.Queue < 0 {
+		add(errors.New("queue must not be negative"))
+	}
+	if c.DrainSeconds < 0 {
+		add(errors.New("drain_seconds must not be negative"))
+	}
+	if err := c.Limits.resources().Validate(); err != nil {
+		add(fmt.Errorf("limits: %w", err))
+	}
+	host := detectLimits()
+	if c.Limits.CPUs > host.CPUs {
+		add(fmt.Errorf("limits.cpus %g is more than the host's %g CPUs", c.Limits.CPUs, host.CPUs))
+	}
+	if c.Limits.MemoryMB > host.MemoryMB {
+		add(fmt.Errorf("limits.memory_mb %d is more than the host's %d MB", c.Limits.MemoryMB, host.MemoryMB))
+	}
+	if c.Output.InlineBytes < 0 || c.Output.InlineBytes > modules.MaxInlineOutputBytes {
+		add(fmt.Errorf("output.inline_bytes must be between 0 and %d", modules.MaxInlineOutputBytes))
+	}
+	if c.Output.MaxBytes < 0 || c.Output.ArtifactBytes < 0 {
+		add(errors.New("output.max_bytes and output.artifact_bytes must not be negative"))
+	}
+	if c.Output.MaxBytes > modules.MaxOutputBytes || c.Output.ArtifactBytes > modules.MaxOutputBytes {
+		add(fmt.Errorf("output.max_bytes and output.artifact_bytes must be at most the server's %d", modules.MaxOutputBytes))
+	}
+	if _, err := c.LoadImagePolicy(); err != nil {
+		add(err)
+	}
+	if _, err := LoadSandboxProfile(c.Sandbox); err != nil {
+		add(err)
+	}
+	if err := c.PriceCard().Validate(); err != nil {
+		add(fmt.Errorf("pricing: %w", err))
+	}
+	return errs
+}
+
+// SessionToken returns the configured token, reading the token file if needed
+func (c *Config) SessionToken() (string, error) {
+	if c.Identity.Token != "" {
+		return c.Identity.Token, nil
+	}
+	if c.Identity.TokenFile == "" {
+		return "", errors.New("identity.token or identity.token_file is required")
+	}
+	data, err := os.ReadFile(c.Identity.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("identity.token_file: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("identity.token_file %s is empty", c.Identity.TokenFile)
+	}
+	return token, nil
+}
+
+// TLSConfig builds the TLS client configuration, or nil when TLS is off
+func (c *Config) TLSConfig() (*tls.Config, error) {
+	t := c.Server.TLS
+	if !t.Enabled {
+		return nil, nil
+	}
+	cfg := &tls.Config{ServerName: t.ServerName, InsecureSkipVerify: t.InsecureSkipVerify}
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("server.tls.ca_file: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("server.tls.ca_file %s holds no PEM certificates", t.CAFile)
+		}
+	}
+	return cfg, nil
+}
+
+// LoadImagePolicy returns the inline policy, the policy file, or the default policy
+func (c *Config) LoadImagePolicy() (*ImagePolicy, error) {
+	if c.ImagePolicy != nil {
+		if err := c.ImagePolicy.Validate(); err != nil {
+			return nil, fmt.Errorf("image_policy: %w", err)
+		}
+		return c.ImagePolicy, nil
+	}
+	if c.ImagePolicyFile != "" {
+		return LoadImagePolicy(c.ImagePolicyFile)
+	}
+	return &DefaultImagePolicy, nil
+}
+
+// JobLimits returns the host's limits with the configured caps applied
+func (c *Config) JobLimits() modules.Resources {
+	limits := detectLimits()
+	caps := c.Limits.resources()
+	if caps.CPUs > 0 {
+		limits.CPUs = caps.CPUs
+	}
+	if caps.MemoryMB > 0 {
+		limits.MemoryMB = caps.MemoryMB
+	}
+	if caps.PidsLimit > 0 {
+		limits.PidsLimit = caps.PidsLimit
+	}
+	if caps.DiskMB > 0 {
+		limits.DiskMB = caps.DiskMB
+	}
+	if caps.TimeoutSeconds > 0 {
+		limits.TimeoutSeconds = caps.TimeoutSeconds
+	}
+	return limits
+}
+
+func (l LimitsConfig) resources() modules.Resources {
+	return modules.Resources{
+		CPUs:           l.CPUs,
+		MemoryMB:       l.MemoryMB,
+		PidsLimit:      l.PidsLimit,
+		DiskMB:         l.DiskMB,
+		TimeoutSeconds: l.TimeoutSeconds,
+	}
+}
+
+// PriceCard returns the configured prices, or nil when the seller sets none
+func (c *Config) PriceCard() *modules.PriceCard {
+	p := c.Pricing
+	if p == (PricingConfig{}) {
+		return nil
+	}
+	return &modules.PriceCard{CPUSecond: p.CPUSecond, GBSecond: p.GBSecond, Minimum: p.Minimum}
+}
+
+// loadSellerConfig reads the config file, or $LUMARIS_CONFIG when none is
+// given, over the defaults and applies the LUMARIS_* environment variables
+func loadSellerConfig(file string) (*Config, error) {
+	if file == "" {
+		file = os.Getenv("LUMARIS_CONFIG")
+	}
+	cfg := DefaultConfig()
+	if file != "" {
+		var err error
+		if cfg, err = LoadConfig(file); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.ApplyEnv(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// ValidateConfigMain is the entry point for "seller validate-config". It
+// reports every problem with the configuration and exits non-zero if any.
+func ValidateConfigMain() {
+	validateFlags := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	configFile := validateFlags.String("config", "", "YAML or JSON seller config file (default: $LUMARIS_CONFIG)")
+	validateFlags.Parse(os.Args[3:])
+	if *configFile == "" && validateFlags.NArg() > 0 {
+		*configFile = validateFlags.Arg(0)
+	}
+
+	cfg, err := loadSellerConfig(*configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if errs := cfg.Validate(); len(errs) > 0 {
+		fmt.Fprintf(os.Stderr, "%d problem(s) found:\n", len(errs))
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "  - %v\n", err)
+		}
+		os.Exit(1)
+	}
+
+	policy, _ := cfg.LoadImagePolicy()
+	queue := cfg.Queue
+	if queue == 0 {
+		queue = cfg.Slots
+	}
+	scheme := "http"
+	if cfg.Server.TLS.Enabled {
+		scheme = "https"
+	}
+	fmt.Println("Configuration is valid.")
+	fmt.Printf("  server:   %s://%s\n", scheme, cfg.Server.Address)
+	fmt.Printf("  runtime:  %s\n", cfg.Runtime)
+	fmt.Printf("  slots:    %d (queue %d)\n", cfg.Slots, queue)
+	limits := cfg.JobLimits()
+	fmt.Printf("  limits:   %g CPUs, %d MB memory, %d pids, %d MB disk, %ds timeout\n",
+		limits.CPUs, limits.MemoryMB, limits.PidsLimit, limits.DiskMB, limits.TimeoutSeconds)
+	fmt.Printf("  images:   allow %s", strings.Join(policy.Allow, ", "))
+	if len(policy.Deny) > 0 {
+		fmt.Printf("; deny %s", strings.Join(policy.Deny, ", "))
+	}
+	if policy.RequireDigest {
+		fmt.Print("; digest required")
+	}
+	fmt.Println()
+	fmt.Printf("  sandbox:  %s\n", cfg.Sandbox)
+	fmt.Printf("  drain:    %ds\n", cfg.DrainSeconds)
+	if p := cfg.PriceCard(); p != nil {
+		fmt.Printf("  pricing:  %g per CPU-second, %g per GB-second, minimum %g\n", p.CPUSecond, p.GBSecond, p.Minimum)
+	} else {
+		fmt.Println("  pricing:  free")
+	}
+}
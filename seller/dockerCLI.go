@@ -0,0 +1,171 @@
+package seller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// DockerCLI is a Runtime that shells out to the docker command
+type DockerCLI struct {
+	// Binary is the docker executable to run
+	Binary string
+}
+
+// NewDockerCLI returns a runtime using the docker binary on the PATH
+func NewDockerCLI() *DockerCLI {
+	return &DockerCLI{Binary: "docker"}
+}
+
+func (d *DockerCLI) Name() string { return "docker-cli" }
+
+// run executes a docker command and returns its trimmed stdout
+func (d *DockerCLI) run(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, d.Binary, args...)
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("docker %s: %s", args[0], strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("docker %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (d *DockerCLI) Version(ctx context.Context) (string, error) {
+	return d.run(ctx, "version", "--format", "{{.Server.Version}}")
+}
+
+func (d *DockerCLI) Pull(ctx context.Context, image string) error {
+	// Skip the registry round trip when the image is already present
+	if _, err := d.run(ctx, "image", "inspect", "--format", "{{.Id}}", image); err == nil {
+		return nil
+	}
+	_, err := d.run(ctx, "pull", "--quiet", image)
+	return err
+}
+
+func (d *DockerCLI) Create(ctx context.Context, spec ContainerSpec) (string, error) {
+	args := []string{"create", "--name", spec.Name}
+	if spec.NetworkDisabled {
+		args = append(args, "--network=none")
+	}
+	args = append(args, resourceFlags(spec.Resources)...)
+	args = append(args, sandboxFlags(spec.Sandbox)...)
+	for _, m := range spec.Mounts {
+		mount := fmt.Sprintf("--mount=type=bind,source=%s,target=%s", m.Source, m.Target)
+		if m.ReadOnly {
+			mount += ",readonly"
+		}
+		args = append(args, mount)
+	}
+	args = append(args, spec.Image)
+	args = append(args, spec.Cmd...)
+	return d.run(ctx, args...)
+}
+
+func (d *DockerCLI) Start(ctx context.Context, id string) error {
+	_, err := d.run(ctx, "start", id)
+	return err
+}
+
+func (d *DockerCLI) Wait(ctx context.Context, id string) (int, error) {
+	out, err := d.run(ctx, "wait", id)
+	if err != nil {
+		return -1, err
+	}
+	code, err := strconv.Atoi(out)
+	if err != nil {
+		return -1, fmt.Errorf("unexpected docker wait output %q", out)
+	}
+	return code, nil
+}
+
+func (d *DockerCLI) Logs(ctx context.Context, id string) (io.ReadCloser, io.ReadCloser, error) {
+	cmd := exec.CommandContext(ctx, d.Binary, "logs", "--follow", id)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("docker logs: %w", err)
+	}
+
+	// Reap the process once both streams have been read to the end
+	outR, outW := io.Pipe()
+	errR, errW := io.Pipe()
+	go func() {
+		done := make(chan struct{}, 2)
+		go func() { io.Copy(outW, stdout); done <- struct{}{} }()
+		go func() { io.Copy(errW, stderr); done <- struct{}{} }()
+		<-done
+		<-done
+		err := cmd.Wait()
+		outW.CloseWithError(err)
+		errW.CloseWithError(err)
+	}()
+	return outR, errR, nil
+}
+
+func (d *DockerCLI) Kill(ctx context.Context, id string) error {
+	_, err := d.run(ctx, "kill", id)
+	return err
+}
+
+func (d *DockerCLI) Remove(ctx context.Context, id string) error {
+	_, err := d.run(ctx, "rm", "--force", id)
+	return err
+}
+
+func (d *DockerCLI) Stats(ctx context.Context, id string) (ContainerStats, error) {
+	out, err := d.run(ctx, "stats", "--no-stream", "--format", "{{json .}}", id)
+	if err != nil {
+		return ContainerStats{}, err
+	}
+
+	var raw struct {
+		CPUPerc  string `json:"CPUPerc"`
+		MemUsage string `json:"MemUsage"`
+	}
+	if err := json.Unmarshal([]byte(out), &raw); err != nil {
+		return ContainerStats{}, fmt.Errorf("unexpected docker stats output: %w", err)
+	}
+
+	// The CLI only reports the current CPU percentage, not cumulative CPU time
+	var stats ContainerStats
+	stats.CPUPercent, _ = strconv.ParseFloat(strings.TrimSuffix(raw.CPUPerc, "%"), 64)
+	if used, _, ok := strings.Cut(raw.MemUsage, "/"); ok {
+		stats.MemoryBytes = parseByteSize(strings.TrimSpace(used))
+	}
+	return stats, nil
+}
+
+// parseByteSize parses sizes printed by docker such as "12.5MiB" or "1.2GB"
+func parseByteSize(s string) uint64 {
+	units := []struct {
+		suffix string
+		factor float64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+		{"kB", 1e3}, {"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+		{"B", 1},
+	}
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			n, err := strconv.ParseFloat(strings.TrimSuffix(s, u.suffix), 64)
+			if err != nil {
+				return 0
+			}
+			return uint64(n * u.factor)
+		}
+	}
+	return 0
+}
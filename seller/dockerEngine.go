@@ -0,0 +1,315 @@
+package seller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/bdr-pro/lumaris/imageref"
+)
+
+// defaultDockerSocket is where the Docker daemon listens on Linux hosts
+const defaultDockerSocket = "/var/run/docker.sock"
+
+// DockerEngine is a Runtime that talks to the Docker Engine API over its unix socket
+type DockerEngine struct {
+	client *http.Client
+}
+
+// NewDockerEngine returns a runtime using the Docker daemon listening on the given socket
+func NewDockerEngine(socket string) *DockerEngine {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}
+	return &DockerEngine{client: &http.Client{Transport: transport}}
+}
+
+func (d *DockerEngine) Name() string { return "docker-engine" }
+
+// do sends an API request and returns the response, failing on unexpected status codes
+func (d *DockerEngine) do(ctx context.Context, method, path string, query url.Values, body interface{}, okStatus ...int) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	// The host is ignored by the unix socket dialer
+	u := "http://docker" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("docker engine %s %s: %w", method, path, err)
+	}
+	for _, code := range okStatus {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+
+	var apiErr struct {
+		Message string `json:"message"`
+	}
+	data, _ := io.ReadAll(resp.Body)
+	if json.Unmarshal(data, &apiErr) != nil || apiErr.Message == "" {
+		apiErr.Message = strings.TrimSpace(string(data))
+	}
+	return nil, fmt.Errorf("docker engine %s %s [%d]: %s", method, path, resp.StatusCode, apiErr.Message)
+}
+
+// call sends an API request, decoding a JSON response into out when it is not nil
+func (d *DockerEngine) call(ctx context.Context, method, path string, query url.Values, body, out interface{}, okStatus ...int) error {
+	resp, err := d.do(ctx, method, path, query, body, okStatus...)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (d *DockerEngine) Version(ctx context.Context) (string, error) {
+	var v struct {
+		Version string `json:"Version"`
+	}
+	err := d.call(ctx, "GET", "/version", nil, nil, &v, http.StatusOK)
+	return v.Version, err
+}
+
+func (d *DockerEngine) Pull(ctx context.Context, image string) error {
+	// Skip the registry round trip when the image is already present
+	if err := d.call(ctx, "GET", "/images/"+image+"/json", nil, nil, nil, http.StatusOK); err == nil {
+		return nil
+	}
+
+	// Without a tag the engine would pull every tag of the repository, so
+	// name the tag, or the digest when the image is pinned, the way the CLI does
+	ref, err := imageref.Parse(image)
+	if err != nil {
+		return err
+	}
+	tag := ref.Tag
+	if ref.Digest != "" {
+		tag = ref.Digest
+	}
+	query := url.Values{"fromImage": {ref.Registry + "/" + ref.Repository}, "tag": {tag}}
+
+	// The pull progress stream has to be read to the end for the pull to finish
+	resp, err := d.do(ctx, "POST", "/images/create", query, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Error string `json:"error"`
+		}
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("docker engine pull %s: %w", image, err)
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("docker engine pull %s: %s", image, msg.Error)
+		}
+	}
+}
+
+func (d *DockerEngine) Create(ctx context.Context, spec ContainerSpec) (string, error) {
+	hostConfig := map[string]interface{}{
+		"NanoCpus":  int64(spec.Resources.CPUs * 1e9),
+		"Memory":    spec.Resources.MemoryMB << 20,
+		"PidsLimit": spec.Resources.PidsLimit,
+	}
+	tmpfs := map[string]string{"/scratch": fmt.Sprintf("rw,size=%dm", spec.Resources.DiskMB)}
+	if spec.NetworkDisabled {
+		hostConfig["NetworkMode"] = "none"
+	}
+	if len(spec.Mounts) > 0 {
+		mounts := make([]map[string]interface{}, 0, len(spec.Mounts))
+		for _, m := range spec.Mounts {
+			mounts = append(mounts, map[string]interface{}{
+				"Type":     "bind",
+				"Source":   m.Source,
+				"Target":   m.Target,
+				"ReadOnly": m.ReadOnly,
+			})
+		}
+		hostConfig["Mounts"] = mounts
+	}
+
+	sandbox := spec.Sandbox
+	hostConfig["ReadonlyRootfs"] = sandbox.ReadOnlyRootfs
+	if sandbox.TmpMB > 0 {
+		tmpfs["/tmp"] = fmt.Sprintf("rw,nosuid,nodev,size=%dm", sandbox.TmpMB)
+	}
+	hostConfig["Tmpfs"] = tmpfs
+	hostConfig["CapDrop"] = sandbox.CapDrop
+	hostConfig["CapAdd"] = sandbox.CapAdd
+	var securityOpt []string
+	if sandbox.NoNewPrivileges {
+		securityOpt = append(securityOpt, "no-new-privileges")
+	}
+	if sandbox.SeccompProfile != "" {
+		// Unlike the CLI, the API takes the profile itself rather than its path
+		profile, err := os.ReadFile(sandbox.SeccompProfile)
+		if err != nil {
+			return "", fmt.Errorf("seccomp profile: %w", err)
+		}
+		securityOpt = append(securityOpt, "seccomp="+string(profile))
+	}
+	hostConfig["SecurityOpt"] = securityOpt
+	if len(sandbox.Ulimits) > 0 {
+		ulimits := make([]map[string]interface{}, 0, len(sandbox.Ulimits))
+		for _, u := range sandbox.Ulimits {
+			ulimits = append(ulimits, map[string]interface{}{"Name": u.Name, "Soft": u.Soft, "Hard": u.Hard})
+		}
+		hostConfig["Ulimits"] = ulimits
+	}
+
+	body := map[string]interface{}{
+		"Image":           spec.Image,
+		"Cmd":             spec.Cmd,
+		"User":            sandbox.User,
+		"NetworkDisabled": spec.NetworkDisabled,
+		"HostConfig":      hostConfig,
+	}
+
+	var created struct {
+		ID string `json:"Id"`
+	}
+	err := d.call(ctx, "POST", "/containers/create", url.Values{"name": {spec.Name}}, body, &created, http.StatusCreated)
+	return created.ID, err
+}
+
+func (d *DockerEngine) Start(ctx context.Context, id string) error {
+	return d.call(ctx, "POST", "/containers/"+id+"/start", nil, nil, nil, http.StatusNoContent, http.StatusNotModified)
+}
+
+func (d *DockerEngine) Wait(ctx context.Context, id string) (int, error) {
+	var res struct {
+		StatusCode int `json:"StatusCode"`
+	}
+	if err := d.call(ctx, "POST", "/containers/"+id+"/wait", nil, nil, &res, http.StatusOK); err != nil {
+		return -1, err
+	}
+	return res.StatusCode, nil
+}
+
+func (d *DockerEngine) Logs(ctx context.Context, id string) (io.ReadCloser, io.ReadCloser, error) {
+	query := url.Values{"follow": {"1"}, "stdout": {"1"}, "stderr": {"1"}}
+	resp, err := d.do(ctx, "GET", "/containers/"+id+"/logs", query, nil, http.StatusOK)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	outR, outW := io.Pipe()
+	errR, errW := io.Pipe()
+	go func() {
+		defer resp.Body.Close()
+		err := demuxLogs(resp.Body, outW, errW)
+		outW.CloseWithError(err)
+		errW.CloseWithError(err)
+	}()
+	return outR, errR, nil
+}
+
+// demuxLogs splits Docker's multiplexed log stream into stdout and stderr.
+// Every frame starts with an 8-byte header: the stream type, three unused
+// bytes, and the big-endian payload length.
+func demuxLogs(r io.Reader, stdout, stderr io.Writer) error {
+	br := bufio.NewReader(r)
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(br, header); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		dst := stdout
+		if header[0] == 2 {
+			dst = stderr
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(dst, br, size); err != nil {
+			return err
+		}
+	}
+}
+
+func (d *DockerEngine) Kill(ctx context.Context, id string) error {
+	return d.call(ctx, "POST", "/containers/"+id+"/kill", nil, nil, nil, http.StatusNoContent)
+}
+
+func (d *DockerEngine) Remove(ctx context.Context, id string) error {
+	return d.call(ctx, "DELETE", "/containers/"+id, url.Values{"force": {"1"}}, nil, nil, http.StatusNoContent, http.StatusNotFound)
+}
+
+func (d *DockerEngine) Stats(ctx context.Context, id string) (ContainerStats, error) {
+	var raw struct {
+		CPUStats struct {
+			CPUUsage struct {
+				TotalUsage uint64 `json:"total_usage"`
+			} `json:"cpu_usage"`
+			SystemUsage uint64 `json:"system_cpu_usage"`
+			OnlineCPUs  uint64 `json:"online_cpus"`
+		} `json:"cpu_stats"`
+		PreCPUStats struct {
+			CPUUsage struct {
+				TotalUsage uint64 `json:"total_usage"`
+			} `json:"cpu_usage"`
+			SystemUsage uint64 `json:"system_cpu_usage"`
+		} `json:"precpu_stats"`
+		MemoryStats struct {
+			Usage uint64 `json:"usage"`
+		} `json:"memory_stats"`
+	}
+	query := url.Values{"stream": {"false"}}
+	if err := d.call(ctx, "GET", "/containers/"+id+"/stats", query, nil, &raw, http.StatusOK); err != nil {
+		return ContainerStats{}, err
+	}
+
+	stats := ContainerStats{
+		CPUNanos:    raw.CPUStats.CPUUsage.TotalUsage,
+		MemoryBytes: raw.MemoryStats.Usage,
+	}
+	// Same formula docker stats uses: container CPU delta over system CPU delta, scaled by CPU count
+	cpuDelta := float64(raw.CPUStats.CPUUsage.TotalUsage) - float64(raw.PreCPUStats.CPUUsage.TotalUsage)
+	sysDelta := float64(raw.CPUStats.SystemUsage) - float64(raw.PreCPUStats.SystemUsage)
+	if cpuDelta > 0 && sysDelta > 0 {
+		stats.CPUPercent = cpuDelta / sysDelta * float64(raw.CPUStats.OnlineCPUs) * 100
+	}
+	return stats, nil
+}
@@ -0,0 +1,75 @@
+package seller
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// fakeEngine serves the Docker Engine API on a unix socket, reporting every
+// image as missing and recording the pulls it is asked for
+func fakeEngine(t *testing.T) (*DockerEngine, func() []url.Values) {
+	dir, err := os.MkdirTemp("", "engine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var pulls []url.Values
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "POST" && req.URL.Path == "/images/create" {
+			mu.Lock()
+			pulls = append(pulls, req.URL.Query())
+			mu.Unlock()
+			w.Write([]byte(`{"status":"Pulling"}`))
+			return
+		}
+		http.Error(w, `{"message":"no such image"}`, http.StatusNotFound)
+	}))
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+
+	return NewDockerEngine(socket), func() []url.Values {
+		mu.Lock()
+		defer mu.Unlock()
+		return pulls
+	}
+}
+
+func TestDockerEnginePullNamesOneTag(t *testing.T) {
+	tests := []struct {
+		image, fromImage, tag string
+	}{
+		{"alpine", "docker.io/library/alpine", "latest"},
+		{"python:3.10", "docker.io/library/python", "3.10"},
+		{"ghcr.io/acme/tool:v1", "ghcr.io/acme/tool", "v1"},
+		{"ghcr.io/acme/tool:v1@sha256:abc", "ghcr.io/acme/tool", "sha256:abc"},
+		{"localhost:5000/app", "localhost:5000/app", "latest"},
+	}
+	for _, tt := range tests {
+		engine, pulls := fakeEngine(t)
+		if err := engine.Pull(context.Background(), tt.image); err != nil {
+			t.Fatalf("pull %s: %v", tt.image, err)
+		}
+		got := pulls()
+		if len(got) != 1 {
+			t.Fatalf("pull %s: want one pull, got %d", tt.image, len(got))
+		}
+		if got[0].Get("fromImage") != tt.fromImage || got[0].Get("tag") != tt.tag {
+			t.Errorf("pull %s: want fromImage=%s tag=%s, got %v", tt.image, tt.fromImage, tt.tag, got[0])
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package seller
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/bdr-pro/lumaris/modules"
+)
+
+// containerName returns the name of the container running a job
+func containerName(jobID string) string {
+	return "lumaris-" + jobID
+}
+
+// runContainer runs a job in a fresh container with the given mounts and
+// returns its exit code. Every line of output is passed to onLine as it is
+// written, tagged with its stream. Timings and sampled resource usage are
+// recorded in usage. When ctx ends first the container is killed.
+func (r *Runner) runContainer(ctx context.Context, job modules.JobRequest, mounts []Mount, usage *modules.Usage, onLine func(stream, line string)) (int, error) {
+	pullStart := time.Now()
+	err := r.Runtime.Pull(ctx, job.Image)
+	usage.ImagePullSeconds = time.Since(pullStart).Seconds()
+	if err != nil {
+		return -1, err
+	}
+
+	// Name the container after the job so it can be found and killed
+	spec := ContainerSpec{
+		Name:            containerName(job.JobID),
+		Image:           job.Image,
+		Cmd:             []string{"sh", "-c", job.Command},
+		Resources:       sandboxResources(r.Sandbox, job.Resources),
+		NetworkDisabled: true,
+		Mounts:          mounts,
+		Sandbox:         r.Sandbox,
+	}
+	id, err := r.Runtime.Create(ctx, spec)
+	if err != nil {
+		return -1, err
+	}
+	// Clean up with a fresh context so it still happens after a timeout or cancellation
+	defer r.Runtime.Remove(context.Background(), id)
+
+	started := time.Now()
+	if err := r.Runtime.Start(ctx, id); err != nil {
+		return -1, err
+	}
+
+	// Sample stats until the container exits
+	var (
+		meter    usageMeter
+		finished time.Time
+	)
+	sampleCtx, stopSampling := context.WithCancel(ctx)
+	sampled := make(chan struct{})
+	go func() {
+		meter.sample(sampleCtx, r.Runtime, id)
+		close(sampled)
+	}()
+	defer func() {
+		stopSampling()
+		<-sampled
+		if finished.IsZero() {
+			finished = time.Now()
+		}
+		usage.StartedAt = started.Unix()
+		usage.FinishedAt = finished.Unix()
+		usage.WallSeconds = finished.Sub(started).Seconds()
+		meter.fill(usage)
+	}()
+
+	stdout, stderr, err := r.Runtime.Logs(ctx, id)
+	if err != nil {
+		r.Runtime.Kill(context.Background(), id)
+		return -1, err
+	}
+
+	var wg sync.WaitGroup
+	collect := func(stream string, rc io.ReadCloser) {
+		defer wg.Done()
+		defer rc.Close()
+		readLines(rc, func(line string) { onLine(stream, line) })
+	}
+	wg.Add(2)
+	go collect("stdout", stdout)
+	go collect("stderr", stderr)
+
+	exitCode, err := r.Runtime.Wait(ctx, id)
+	finished = time.Now()
+	if ctx.Err() != nil {
+		r.Runtime.Kill(context.Background(), id)
+	} else if stats, serr := r.Runtime.Stats(context.Background(), id); serr == nil {
+		// A last sample of the exited container catches the final cumulative CPU time
+		meter.add(stats, 0)
+	}
+	wg.Wait()
+
+	return exitCode, err
+}
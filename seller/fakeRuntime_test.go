@@ -0,0 +1,247 @@
+package seller
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeOutcome is what a fake container does once started
+type FakeOutcome struct {
+	Stdout   string        // Written to the container's stdout
+	Stderr   string        // Written to the container's stderr
+	ExitCode int           // Exit code once the container finishes
+	Duration time.Duration // How long the container runs
+	// Files written to the mount with the given target when the container
+	// starts, keyed by path relative to it, e.g. {"/out": {"result.txt": "42"}}
+	Files map[string]map[string]string
+	Stats ContainerStats // Returned by every Stats call
+}
+
+// FakeRuntime is an in-memory Runtime for exercising the runner without Docker
+type FakeRuntime struct {
+	// Behavior decides what each container does; by default it exits 0 without output
+	Behavior func(spec ContainerSpec) FakeOutcome
+
+	mu         sync.Mutex
+	nextID     int
+	pulled     []string
+	containers map[string]*fakeContainer
+}
+
+type fakeContainer struct {
+	spec     ContainerSpec
+	outcome  FakeOutcome
+	started  bool
+	killed   bool
+	removed  bool
+	exitCode int
+	done     chan struct{}
+}
+
+// NewFakeRuntime returns an empty fake runtime
+func NewFakeRuntime() *FakeRuntime {
+	return &FakeRuntime{containers: make(map[string]*fakeContainer)}
+}
+
+func (f *FakeRuntime) Name() string { return "fake" }
+
+func (f *FakeRuntime) Version(ctx context.Context) (string, error) { return "fake", nil }
+
+func (f *FakeRuntime) Pull(ctx context.Context, image string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pulled = append(f.pulled, image)
+	return nil
+}
+
+func (f *FakeRuntime) Create(ctx context.Context, spec ContainerSpec) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, c := range f.containers {
+		if c.spec.Name == spec.Name && !c.removed {
+			return "", fmt.Errorf("container name %q is already in use", spec.Name)
+		}
+	}
+
+	outcome := FakeOutcome{}
+	if f.Behavior != nil {
+		outcome = f.Behavior(spec)
+	}
+	f.nextID++
+	id := fmt.Sprintf("fake-%d", f.nextID)
+	f.containers[id] = &fakeContainer{spec: spec, outcome: outcome, done: make(chan struct{})}
+	return id, nil
+}
+
+// container looks up a container by ID or name
+func (f *FakeRuntime) container(id string) (*fakeContainer, error) {
+	if c, ok := f.containers[id]; ok {
+		return c, nil
+	}
+	for _, c := range f.containers {
+		if c.spec.Name == id && !c.removed {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("no such container: %s", id)
+}
+
+func (f *FakeRuntime) Start(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, err := f.container(id)
+	if err != nil {
+		return err
+	}
+	if c.started {
+		return nil
+	}
+	c.started = true
+
+	if err := writeFakeFiles(c.spec.Mounts, c.outcome.Files); err != nil {
+		return err
+	}
+
+	go func() {
+		<-time.After(c.outcome.Duration)
+		f.finish(c, c.outcome.ExitCode)
+	}()
+	return nil
+}
+
+// writeFakeFiles writes the files of a fake outcome into the host directories of the matching mounts
+func writeFakeFiles(mounts []Mount, files map[string]map[string]string) error {
+	for _, m := range mounts {
+		for name, content := range files[m.Target] {
+			p := filepath.Join(m.Source, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// finish marks the container as exited, unless it already has
+func (f *FakeRuntime) finish(c *fakeContainer, code int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	select {
+	case <-c.done:
+	default:
+		c.exitCode = code
+		close(c.done)
+	}
+}
+
+func (f *FakeRuntime) Wait(ctx context.Context, id string) (int, error) {
+	f.mu.Lock()
+	c, err := f.container(id)
+	f.mu.Unlock()
+	if err != nil {
+		return -1, err
+	}
+
+	select {
+	case <-c.done:
+		return c.exitCode, nil
+	case <-ctx.Done():
+		return -1, ctx.Err()
+	}
+}
+
+func (f *FakeRuntime) Logs(ctx context.Context, id string) (io.ReadCloser, io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, err := f.container(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return io.NopCloser(strings.NewReader(c.outcome.Stdout)), io.NopCloser(strings.NewReader(c.outcome.Stderr)), nil
+}
+
+func (f *FakeRuntime) Kill(ctx context.Context, id string) error {
+	f.mu.Lock()
+	c, err := f.container(id)
+	if err == nil {
+		c.killed = true
+	}
+	f.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Same exit code Docker reports for SIGKILL
+	f.finish(c, 137)
+	return nil
+}
+
+func (f *FakeRuntime) Remove(ctx context.Context, id string) error {
+	f.mu.Lock()
+	c, err := f.container(id)
+	if err == nil {
+		c.removed = true
+	}
+	f.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	f.finish(c, 137)
+	return nil
+}
+
+func (f *FakeRuntime) Stats(ctx context.Context, id string) (ContainerStats, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, err := f.container(id)
+	if err != nil {
+		return ContainerStats{}, err
+	}
+	return c.outcome.Stats, nil
+}
+
+// Pulled returns every image pulled so far
+func (f *FakeRuntime) Pulled() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.pulled...)
+}
+
+// Specs returns the spec of every container created so far
+func (f *FakeRuntime) Specs() []ContainerSpec {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	specs := make([]ContainerSpec, 0, len(f.containers))
+	for i := 1; i <= f.nextID; i++ {
+		if c, ok := f.containers[fmt.Sprintf("fake-%d", i)]; ok {
+			specs = append(specs, c.spec)
+		}
+	}
+	return specs
+}
+
+// Killed reports whether the named container was killed
+func (f *FakeRuntime) Killed(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.containers {
+		if c.spec.Name == name && c.killed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package seller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/bdr-pro/lumaris/bundle"
+	"github.com/bdr-pro/lumaris/modules"
+)
+
+// DefaultMaxArtifacts is how many bytes of files from /out are returned per job
+const DefaultMaxArtifacts = 64 << 20
+
+// jobFiles are the host directories mounted into a job's container
+type jobFiles struct {
+	inDir  string // Unpacked inputs, mounted read-only at /in; empty without inputs
+	outDir string // Mounted at /out and collected as artifacts once the job ends
+}
+
+// prepareJobFiles verifies and unpacks a job's inputs and creates its output directory
+func prepareJobFiles(job modules.JobRequest) (*jobFiles, error) {
+	files, tarball, err := job.Inputs.Decode()
+	if err != nil {
+		return nil, err
+	}
+
+	f := &jobFiles{}
+	if f.outDir, err = os.MkdirTemp("", "lumaris-out-"); err != nil {
+		return nil, err
+	}
+	// The container may run as any user, so anyone must be able to write its output
+	if err := os.Chmod(f.outDir, 0777); err != nil {
+		f.remove()
+		return nil, err
+	}
+	if job.Inputs.Empty() {
+		return f, nil
+	}
+
+	if f.inDir, err = os.MkdirTemp("", "lumaris-in-"); err != nil {
+		f.remove()
+		return nil, err
+	}
+	if err := f.unpackInputs(files, tarball, job.Resources.DiskMB<<20); err != nil {
+		f.remove()
+		return nil, err
+	}
+	return f, nil
+}
+
+// unpackInputs writes the input files and bundle into inDir, readable by any container user
+func (f *jobFiles) unpackInputs(files map[string][]byte, tarball []byte, maxBytes int64) error {
+	if err := os.Chmod(f.inDir, 0755); err != nil {
+		return err
+	}
+	for name, data := range files {
+		p := filepath.Join(f.inDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(p, data, 0644); err != nil {
+			return err
+		}
+	}
+	if len(tarball) > 0 {
+		if _, err := bundle.ExtractBytes(tarball, f.inDir, maxBytes); err != nil {
+			return fmt.Errorf("input bundle: %w", err)
+		}
+	}
+	return nil
+}
+
+// mounts returns the container mounts for the directories
+func (f *jobFiles) mounts() []Mount {
+	mounts := []Mount{{Source: f.outDir, Target: "/out"}}
+	if f.inDir != "" {
+		mounts = append(mounts, Mount{Source: f.inDir, Target: "/in", ReadOnly: true})
+	}
+	return mounts
+}
+
+// remove deletes the directories from the host
+func (f *jobFiles) remove() {
+	if f.inDir != "" {
+		os.RemoveAll(f.inDir)
+	}
+	if f.outDir != "" {
+		os.RemoveAll(f.outDir)
+	}
+}
+
+// collectArtifacts bundles the files a job left in /out and uploads the
+// bundle as the "artifacts" stream. It returns nil when /out is empty.
+func (r *Runner) collectArtifacts(jobID string, f *jobFiles) (*modules.Artifacts, *modules.OutputOverflow, error) {
+	tmp, err := os.CreateTemp("", "lumaris-artifacts-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	files, skipped, err := bundle.Create(io.MultiWriter(tmp, h), f.outDir, r.MaxArtifacts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(files) == 0 && len(skipped) == 0 {
+		return nil, nil, nil
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Archive headers can push incompressible files past the server's cap
+	if size > modules.MaxOutputBytes {
+		return nil, nil, fmt.Errorf("artifact bundle is %d bytes, more than the server accepts (%d)", size, modules.MaxOutputBytes)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	overflow, err := r.uploadChunks(jobID, "artifacts", tmp)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	artifacts := &modules.Artifacts{
+		Files:   files,
+		Bytes:   size,
+		SHA256:  hex.EncodeToString(h.Sum(nil)),
+		Skipped: skipped,
+	}
+	return artifacts, overflow, nil
+}
@@ -0,0 +1,210 @@
+package seller
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bdr-pro/lumaris/modules"
+	"github.com/bdr-pro/lumaris/rpcclient"
+)
+
+const (
+	// logFlushInterval is how often buffered output lines are sent to the server
+	logFlushInterval = time.Second
+	// maxLogBatchBytes caps the encoded lines of one log chunk, well below
+	// modules.MaxLogChunkBytes; a batch is also flushed early once it is this large
+	maxLogBatchBytes = 64 * 1024
+	// maxLogLineBytes splits longer lines, so that a single line fits in a
+	// batch even when every byte of it needs escaping
+	maxLogLineBytes = 8 * 1024
+	// maxPendingLogBytes caps the lines held while the server cannot be
+	// reached; the oldest lines are dropped beyond it
+	maxPendingLogBytes = 4 << 20
+	// finalFlushAttempts is how often the last lines of a job are sent before they are given up on
+	finalFlushAttempts = 3
+)
+
+// readLines calls emit for every line read from r, newline included. Lines
+// longer than the read buffer are emitted in pieces so a single huge line
+// cannot stall the container's output.
+func readLines(r io.Reader, emit func(line string)) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	for {
+		line, err := br.ReadSlice('\n')
+		if len(line) > 0 {
+			emit(string(line))
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// logShipper batches a job's output lines and forwards them to the server
+// through append_job_log while the job runs. A chunk that fails to send is
+// sent again with the same sequence number, so followers never see a gap.
+type logShipper struct {
+	r     *Runner
+	jobID string
+	seq   int // Last chunk the server stored; only used by run
+
+	mu      sync.Mutex
+	pending []pendingLine
+	size    int
+	dropped int
+	full    bool // The server's log limit for the job was reached
+	flushCh chan struct{}
+}
+
+// pendingLine is a queued line with its size once JSON encoded
+type pendingLine struct {
+	line modules.LogLine
+	size int
+}
+
+func newLogShipper(r *Runner, jobID string) *logShipper {
+	return &logShipper{r: r, jobID: jobID, flushCh: make(chan struct{}, 1)}
+}
+
+// add queues a line of output from the given stream
+func (s *logShipper) add(stream, line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.full {
+		return
+	}
+	for _, text := range splitLine(strings.TrimSuffix(line, "\n"), maxLogLineBytes) {
+		l := modules.LogLine{Stream: stream, Text: text}
+		encoded, _ := json.Marshal(l)
+		s.pending = append(s.pending, pendingLine{line: l, size: len(encoded) + 1})
+		s.size += len(encoded) + 1
+	}
+	for s.size > maxPendingLogBytes && len(s.pending) > 0 {
+		if s.dropped == 0 {
+			log.Printf("Dropping logs of job %s: the server is not taking them", s.jobID)
+		}
+		s.size -= s.pending[0].size
+		s.pending = s.pending[1:]
+		s.dropped++
+	}
+	if s.size >= maxLogBatchBytes {
+		select {
+		case s.flushCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run flushes queued lines periodically until done is closed, then flushes what is left
+func (s *logShipper) run(done <-chan struct{}) {
+	ticker := time.NewTicker(logFlushInterval)
+	defer ticker.Stop()
+	failing := false
+	for {
+		select {
+		case <-done:
+			s.finish()
+			return
+		case <-ticker.C:
+		case <-s.flushCh:
+		}
+		// Failed chunks are kept and sent again on the next flush
+		err := s.flush()
+		if err != nil && !failing {
+			log.Printf("Failed to forward logs of job %s, retrying: %v", s.jobID, err)
+		}
+		failing = err != nil
+	}
+}
+
+// finish sends the last queued lines, retrying a few times before giving up on them
+func (s *logShipper) finish() {
+	var err error
+	for attempt := 1; attempt <= finalFlushAttempts; attempt++ {
+		if err = s.flush(); err == nil {
+			return
+		}
+		time.Sleep(time.Duration(attempt) * logFlushInterval)
+	}
+	log.Printf("Giving up on the last logs of job %s: %v", s.jobID, err)
+}
+
+// flush sends the queued lines as consecutive log chunks, each within
+// maxLogBatchBytes. It stops at the first chunk the server does not take,
+// leaving it queued under the same sequence number.
+func (s *logShipper) flush() error {
+	for {
+		s.mu.Lock()
+		n, size := 0, 0
+		for n < len(s.pending) && (n == 0 || size+s.pending[n].size <= maxLogBatchBytes) {
+			size += s.pending[n].size
+			n++
+		}
+		if n == 0 {
+			s.mu.Unlock()
+			return nil
+		}
+		batch := s.pending[:n:n]
+		s.pending = s.pending[n:]
+		s.size -= size
+		s.mu.Unlock()
+
+		lines := make([]modules.LogLine, 0, len(batch))
+		for _, p := range batch {
+			lines = append(lines, p.line)
+		}
+		if _, err := s.r.rpc("append_job_log", map[string]interface{}{
+			"job_id": s.jobID,
+			"seq":    s.seq + 1,
+			"lines":  lines,
+		}); err != nil {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if e, ok := err.(*rpcclient.Error); ok && strings.Contains(e.Body, modules.LogLimitMessage) {
+				// Nothing more will be stored for this attempt, so stop sending
+				log.Printf("Log of job %s is full, dropping the rest of its output", s.jobID)
+				s.full = true
+				s.pending, s.size = nil, 0
+				return nil
+			}
+			s.pending = append(batch, s.pending...)
+			s.size += size
+			return err
+		}
+		s.seq++
+
+		s.mu.Lock()
+		if s.dropped > 0 {
+			log.Printf("Dropped %d line(s) of logs of job %s", s.dropped, s.jobID)
+			s.dropped = 0
+		}
+		s.mu.Unlock()
+	}
+}
+
+// splitLine cuts text into pieces of at most max bytes without splitting a UTF-8 character
+func splitLine(text string, max int) []string {
+	var pieces []string
+	for len(text) > max {
+		cut := max
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		if cut == 0 {
+			cut = max
+		}
+		pieces = append(pieces, text[:cut])
+		text = text[cut:]
+	}
+	return append(pieces, text)
+}
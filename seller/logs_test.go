@@ -0,0 +1,100 @@
+package seller
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/bdr-pro/lumaris/modules"
+)
+
+func TestLogShipperSplitsBatchesBelowServerLimit(t *testing.T) {
+	server := newFakeServer(t)
+	s := newLogShipper(newTestRunner(server, NewFakeRuntime()), "job-1")
+
+	// Control characters are escaped to six bytes each in JSON
+	line := strings.Repeat("\x01", 60*1024) + "\n"
+	for i := 0; i < 8; i++ {
+		s.add("stdout", line)
+	}
+	if err := s.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	payloads := server.payloads("append_job_log")
+	if len(payloads) < 2 {
+		t.Fatalf("want the output split over several chunks, got %d", len(payloads))
+	}
+	var text int
+	for i, p := range payloads {
+		if len(p) > modules.MaxLogChunkBytes {
+			t.Errorf("chunk %d is %d bytes, above the server's %d", i+1, len(p), modules.MaxLogChunkBytes)
+		}
+		var chunk struct {
+			Seq   int               `json:"seq"`
+			Lines []modules.LogLine `json:"lines"`
+		}
+		if err := json.Unmarshal(p, &chunk); err != nil {
+			t.Fatal(err)
+		}
+		if chunk.Seq != i+1 {
+			t.Errorf("chunk %d has seq %d", i+1, chunk.Seq)
+		}
+		for _, l := range chunk.Lines {
+			text += len(l.Text)
+		}
+	}
+	if want := 8 * (len(line) - 1); text != want {
+		t.Errorf("want %d bytes of text forwarded, got %d", want, text)
+	}
+}
+
+func TestLogShipperResendsFailedChunkWithSameSeq(t *testing.T) {
+	server := newFakeServer(t)
+	s := newLogShipper(newTestRunner(server, NewFakeRuntime()), "job-1")
+
+	server.rejectRPC("append_job_log")
+	s.add("stdout", "first\n")
+	if err := s.flush(); err == nil {
+		t.Fatal("want the rejected chunk to fail")
+	}
+	server.acceptRPC("append_job_log")
+	s.add("stderr", "second\n")
+	if err := s.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	payloads := server.payloads("append_job_log")
+	if len(payloads) != 2 {
+		t.Fatalf("want one failed and one resent chunk, got %d", len(payloads))
+	}
+	var chunk struct {
+		Seq   int               `json:"seq"`
+		Lines []modules.LogLine `json:"lines"`
+	}
+	if err := json.Unmarshal(payloads[1], &chunk); err != nil {
+		t.Fatal(err)
+	}
+	if chunk.Seq != 1 || len(chunk.Lines) != 2 || chunk.Lines[0].Text != "first" || chunk.Lines[1].Text != "second" {
+		t.Errorf("want both lines resent as chunk 1, got %+v", chunk)
+	}
+}
+
+func TestLogShipperStopsOnceLogIsFull(t *testing.T) {
+	server := newFakeServer(t)
+	s := newLogShipper(newTestRunner(server, NewFakeRuntime()), "job-1")
+
+	server.rejectRPCWith("append_job_log", modules.LogLimitMessage)
+	s.add("stdout", "first\n")
+	if err := s.flush(); err != nil {
+		t.Fatalf("want a full log to be no error, got %v", err)
+	}
+	s.add("stdout", "second\n")
+	if err := s.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if payloads := server.payloads("append_job_log"); len(payloads) != 1 {
+		t.Errorf("want nothing sent after the log is full, got %d chunks", len(payloads))
+	}
+}
@@ -0,0 +1,120 @@
+package seller
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bdr-pro/lumaris/modules"
+)
+
+const (
+	// DefaultInlineOutput is how much of each stream is sent inline in the JobResult
+	DefaultInlineOutput = 16 * 1024
+	// DefaultMaxOutput is how much of each stream is kept at all
+	DefaultMaxOutput = 64 << 20
+)
+
+// outputBuffer keeps the first bytes of a stream in memory and spills the
+// rest to a temporary file, dropping whatever exceeds the overall limit
+type outputBuffer struct {
+	inlineLimit int
+	maxBytes    int64
+
+	inline    bytes.Buffer
+	spill     *os.File
+	spilled   int64
+	total     int64 // Every byte written, including dropped ones
+	truncated bool
+	err       error
+}
+
+func newOutputBuffer(inlineLimit int, maxBytes int64) *outputBuffer {
+	return &outputBuffer{inlineLimit: inlineLimit, maxBytes: maxBytes}
+}
+
+// Write never fails so the container's output keeps being drained; spill
+// errors are remembered and reported by upload
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	b.total += int64(n)
+	if room := b.inlineLimit - b.inline.Len(); room > 0 {
+		if room > len(p) {
+			room = len(p)
+		}
+		b.inline.Write(p[:room])
+		p = p[room:]
+	}
+	if len(p) == 0 || b.err != nil {
+		return n, nil
+	}
+
+	if room := b.maxBytes - int64(b.inline.Len()) - b.spilled; int64(len(p)) > room {
+		b.truncated = true
+		if room <= 0 {
+			return n, nil
+		}
+		p = p[:room]
+	}
+	if b.spill == nil {
+		if b.spill, b.err = os.CreateTemp("", "lumaris-output-*"); b.err != nil {
+			return n, nil
+		}
+	}
+	written, err := b.spill.Write(p)
+	b.spilled += int64(written)
+	b.err = err
+	return n, nil
+}
+
+// close removes the spill file
+func (b *outputBuffer) close() {
+	if b.spill != nil {
+		b.spill.Close()
+		os.Remove(b.spill.Name())
+	}
+}
+
+// uploadOutput sends the spilled part of a stream to the server in chunks
+// and returns its description for the JobResult, or nil if nothing spilled
+func (r *Runner) uploadOutput(jobID, stream string, b *outputBuffer) (*modules.OutputOverflow, error) {
+	if b.err != nil {
+		return nil, fmt.Errorf("buffering %s: %w", stream, b.err)
+	}
+	if b.spill == nil {
+		return nil, nil
+	}
+	if _, err := b.spill.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return r.uploadChunks(jobID, stream, b.spill)
+}
+
+// uploadChunks sends everything read from src to the server as numbered chunks of a stream
+func (r *Runner) uploadChunks(jobID, stream string, src io.Reader) (*modules.OutputOverflow, error) {
+	overflow := &modules.OutputOverflow{}
+	buf := make([]byte, modules.MaxOutputChunkBytes)
+	for {
+		n, err := io.ReadFull(src, buf)
+		if n > 0 {
+			if _, err := r.rpc("upload_job_output", map[string]interface{}{
+				"job_id": jobID,
+				"stream": stream,
+				"index":  overflow.Chunks,
+				"data":   base64.StdEncoding.EncodeToString(buf[:n]),
+			}); err != nil {
+				return nil, fmt.Errorf("uploading %s: %w", stream, err)
+			}
+			overflow.Chunks++
+			overflow.Bytes += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return overflow, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
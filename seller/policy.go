@@ -0,0 +1,83 @@
+package seller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bdr-pro/lumaris/imageref"
+)
+
+// ImagePolicy decides which images the seller is willing to run
+type ImagePolicy struct {
+	// Allow lists the image patterns the seller runs; they are also the
+	// capabilities it advertises. See imageref.Match for the syntax.
+	Allow []string `yaml:"allow" json:"allow"`
+	// Deny lists image patterns that are never run, even if allowed
+	Deny []string `yaml:"deny" json:"deny,omitempty"`
+	// RequireDigest refuses images that are not pinned by digest
+	RequireDigest bool `yaml:"require_digest" json:"require_digest,omitempty"`
+}
+
+// DefaultImagePolicy is used when the seller has no policy file
+var DefaultImagePolicy = ImagePolicy{
+	Allow: []string{"python:3.10", "node:16", "ubuntu:latest"},
+}
+
+// LoadImagePolicy reads a JSON policy file
+func LoadImagePolicy(file string) (*ImagePolicy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var policy ImagePolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("invalid image policy %s: %w", file, err)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid image policy %s: %w", file, err)
+	}
+	return &policy, nil
+}
+
+// Validate checks every pattern in the policy
+func (p *ImagePolicy) Validate() error {
+	if len(p.Allow) == 0 {
+		return errors.New("image policy allows no images")
+	}
+	for _, pattern := range append(append([]string(nil), p.Allow...), p.Deny...) {
+		if err := imageref.ValidPattern(pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check returns the reason the policy refuses an image, or nil if it may run
+func (p *ImagePolicy) Check(image string) error {
+	ref, err := imageref.Parse(image)
+	if err != nil {
+		return err
+	}
+	for _, pattern := range p.Deny {
+		if imageref.Match(pattern, image) {
+			return fmt.Errorf("image %s is denied by pattern %q", ref, pattern)
+		}
+	}
+	if p.RequireDigest && ref.Digest == "" {
+		return fmt.Errorf("image %s is not pinned by digest (use name@sha256:...)", ref)
+	}
+	for _, pattern := range p.Allow {
+		if imageref.Match(pattern, image) {
+			return nil
+		}
+	}
+	return fmt.Errorf("image %s is not in the allowed list (%s)", ref, strings.Join(p.Allow, ", "))
+}
+
+// Capabilities returns the image patterns advertised to the server
+func (p *ImagePolicy) Capabilities() []string {
+	return append([]string(nil), p.Allow...)
+}
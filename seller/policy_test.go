@@ -0,0 +1,65 @@
+package seller
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImagePolicyCheck(t *testing.T) {
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		name   string
+		policy ImagePolicy
+		image  string
+		refuse string // Part of the refusal reason, empty when the image may run
+	}{
+		{"default allows its images", DefaultImagePolicy, "python:3.10", ""},
+		{"default names latest", DefaultImagePolicy, "ubuntu", ""},
+		{"default refuses other tags", DefaultImagePolicy, "python:3.11", "not in the allowed list"},
+		{"tag wildcard", ImagePolicy{Allow: []string{"python:3.*"}}, "docker.io/library/python:3.12", ""},
+		{"no tag allows every tag", ImagePolicy{Allow: []string{"ghcr.io/acme/tool"}}, "ghcr.io/acme/tool:7", ""},
+		{"deny wins over allow", ImagePolicy{Allow: []string{"python:*"}, Deny: []string{"python:3.6*"}}, "python:3.6.15", "denied by pattern"},
+		{"deny leaves other tags", ImagePolicy{Allow: []string{"python:*"}, Deny: []string{"python:3.6*"}}, "python:3.10", ""},
+		{"deny without tag", ImagePolicy{Allow: []string{"*"}, Deny: []string{"ghcr.io/acme/experimental"}}, "ghcr.io/acme/experimental:1", "denied by pattern"},
+		{"digest required", ImagePolicy{Allow: []string{"python:*"}, RequireDigest: true}, "python:3.10", "not pinned by digest"},
+		{"digest given", ImagePolicy{Allow: []string{"python"}, RequireDigest: true}, "python@" + digest, ""},
+		{"digest does not bypass deny", ImagePolicy{Allow: []string{"*"}, Deny: []string{"python"}, RequireDigest: true}, "python@" + digest, "denied by pattern"},
+		{"invalid image", DefaultImagePolicy, "python 3", "invalid image reference"},
+	}
+	for _, tt := range tests {
+		err := tt.policy.Check(tt.image)
+		switch {
+		case tt.refuse == "" && err != nil:
+			t.Errorf("%s: Check(%q) = %v, want nil", tt.name, tt.image, err)
+		case tt.refuse != "" && (err == nil || !strings.Contains(err.Error(), tt.refuse)):
+			t.Errorf("%s: Check(%q) = %v, want %q", tt.name, tt.image, err, tt.refuse)
+		}
+	}
+}
+
+func TestLoadImagePolicy(t *testing.T) {
+	dir := t.TempDir()
+	load := func(content string) (*ImagePolicy, error) {
+		file := filepath.Join(dir, "policy.json")
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return LoadImagePolicy(file)
+	}
+
+	policy, err := load(`{"allow": ["python:3.*"], "deny": ["python:3.6"], "require_digest": true}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policy.Allow) != 1 || len(policy.Deny) != 1 || !policy.RequireDigest {
+		t.Errorf("got %+v", policy)
+	}
+
+	for _, content := range []string{`{}`, `{"allow": ["python:["]}`, `{"allow": ["*"], "deny": ["bad ref"]}`, `not json`} {
+		if _, err := load(content); err == nil {
+			t.Errorf("want policy %s refused", content)
+		}
+	}
+}
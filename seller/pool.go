@@ -0,0 +1,112 @@
+package seller
+
+import (
+	"log"
+	"sync"
+
+	"github.com/bdr-pro/lumaris/modules"
+)
+
+// workerPool runs offered jobs on a fixed number of slots. Offers that
+// arrive while every slot is busy wait in a bounded local queue; offers
+// beyond that are refused so other sellers can claim them.
+type workerPool struct {
+	slots int
+	queue chan modules.JobRequest
+	run   func(modules.JobRequest)
+	// changed is called whenever the number of free slots may have changed
+	changed func()
+
+	mu      sync.Mutex
+	pending map[string]bool // Jobs queued or running here
+	stopped bool            // Set once the pool takes no more jobs
+	workers sync.WaitGroup
+}
+
+func newWorkerPool(slots, queueSize int, run func(modules.JobRequest), changed func()) *workerPool {
+	return &workerPool{
+		slots:   slots,
+		queue:   make(chan modules.JobRequest, queueSize),
+		run:     run,
+		changed: changed,
+		pending: make(map[string]bool),
+	}
+}
+
+// start launches one worker per slot
+func (p *workerPool) start() {
+	p.workers.Add(p.slots)
+	for i := 0; i < p.slots; i++ {
+		go p.worker()
+	}
+}
+
+// submit admits a job into the local queue. It returns false when the job
+// is already here or the queue is full.
+func (p *workerPool) submit(job modules.JobRequest) bool {
+	p.mu.Lock()
+	if p.stopped || p.pending[job.JobID] {
+		p.mu.Unlock()
+		return false
+	}
+	select {
+	case p.queue <- job:
+		p.pending[job.JobID] = true
+	default:
+		p.mu.Unlock()
+		return false
+	}
+	p.mu.Unlock()
+
+	p.changed()
+	return true
+}
+
+// free returns how many more jobs the seller can take without queueing them
+func (p *workerPool) free() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if free := p.slots - len(p.pending); free > 0 {
+		return free
+	}
+	return 0
+}
+
+// stop refuses further offers and drops the queued ones, which were never
+// claimed. Jobs already running carry on; wait returns once they are done.
+func (p *workerPool) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.stopped {
+		p.stopped = true
+		close(p.queue)
+	}
+}
+
+// wait blocks until the pool is stopped and every running job has finished
+func (p *workerPool) wait() {
+	p.workers.Wait()
+}
+
+// isStopped reports whether stop has been called
+func (p *workerPool) isStopped() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stopped
+}
+
+func (p *workerPool) worker() {
+	defer p.workers.Done()
+	for job := range p.queue {
+		if p.isStopped() {
+			log.Printf("Dropping queued job %s: seller is draining", job.JobID)
+		} else {
+			p.run(job)
+		}
+
+		p.mu.Lock()
+		delete(p.pending, job.JobID)
+		p.mu.Unlock()
+		p.changed()
+	}
+}
@@ -0,0 +1,54 @@
+package seller
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/bdr-pro/lumaris/modules"
+)
+
+// detectLimits returns the most resources this host offers to a single job
+func detectLimits() modules.Resources {
+	return modules.Resources{
+		CPUs:           float64(runtime.NumCPU()),
+		MemoryMB:       hostMemoryMB(),
+		PidsLimit:      1024,
+		DiskMB:         10240,
+		TimeoutSeconds: 3600,
+	}
+}
+
+// hostMemoryMB reads the total memory of the host, falling back to 2 GiB when it is unknown
+func hostMemoryMB() int64 {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 2048
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			if kb, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+				return kb / 1024
+			}
+		}
+	}
+	return 2048
+}
+
+// resourceFlags turns a job's resources into docker create flags. The disk
+// allowance becomes a size-limited tmpfs scratch directory at /scratch.
+func resourceFlags(r modules.Resources) []string {
+	return []string{
+		fmt.Sprintf("--cpus=%g", r.CPUs),
+		fmt.Sprintf("--memory=%dm", r.MemoryMB),
+		fmt.Sprintf("--pids-limit=%d", r.PidsLimit),
+		fmt.Sprintf("--tmpfs=/scratch:rw,size=%dm", r.DiskMB),
+	}
+}
@@ -1,142 +1,706 @@
 package seller
 
 import (
-	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
 	"os/signal"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/bdr-pro/lumaris/auth"
 	"github.com/bdr-pro/lumaris/modules"
-	"github.com/google/uuid"
+	"github.com/bdr-pro/lumaris/realtime"
+	"github.com/bdr-pro/lumaris/rpcclient"
+)
+
+const (
+	// DefaultDrainSeconds is how long running jobs get to finish when the seller shuts down
+	DefaultDrainSeconds = 300
+	// interruptTimeout is how long interrupted jobs get to report back before the seller exits
+	interruptTimeout = 30 * time.Second
+	// submitAttempts is how many times a result is sent before it is given up on
+	submitAttempts = 5
+	// submitBackoff is the wait before a result is sent again, doubled after every attempt
+	submitBackoff = time.Second
+	// renewRetryInterval is the wait before a failed lease renewal is tried again
+	renewRetryInterval = 5 * time.Second
 )
 
 // RunnerMain is the entry point for the seller runner
 func RunnerMain() {
-	nakamaServer := flag.String("server", "127.0.0.1:7350", "Nakama server address")
-	sessionToken := flag.String("token", "", "Nakama session token")
-	flag.Parse()
+	if len(os.Args) > 2 && os.Args[2] == "validate-config" {
+		ValidateConfigMain()
+		return
+	}
+
+	runnerFlags := flag.NewFlagSet("seller", flag.ExitOnError)
+	configFile := runnerFlags.String("config", "", "YAML or JSON seller config file (default: $LUMARIS_CONFIG)")
+	nakamaServer := runnerFlags.String("server", "127.0.0.1:7350", "Nakama server address")
+	sessionToken := runnerFlags.String("token", "", "Nakama session token")
+	runtimeName := runnerFlags.String("runtime", "docker-cli", "Container runtime: docker-cli or docker-engine")
+	inlineOutput := runnerFlags.Int("inline-output", DefaultInlineOutput, "Bytes of stdout and stderr sent inline with a result; the rest is uploaded separately")
+	maxOutput := runnerFlags.Int64("max-output", DefaultMaxOutput, "Bytes of stdout and stderr kept per job; anything beyond is dropped")
+	policyFile := runnerFlags.String("image-policy", "", "JSON file listing the images this seller runs (default: python:3.10, node:16, ubuntu:latest)")
+	sandboxName := runnerFlags.String("sandbox", "default", "Sandbox profile: default, hardened, or a JSON profile file")
+	slots := runnerFlags.Int("slots", 1, "Jobs run at the same time")
+	queueSize := runnerFlags.Int("queue", 0, "Offers kept waiting for a free slot (default: same as -slots)")
+	maxArtifacts := runnerFlags.Int64("max-artifacts", DefaultMaxArtifacts, "Bytes of files from /out returned per job")
+	drainSeconds := runnerFlags.Int("drain-seconds", DefaultDrainSeconds, "Seconds running jobs get to finish on shutdown before they are interrupted")
+	runnerFlags.Parse(os.Args[2:])
 
-	if *sessionToken == "" {
-		log.Fatal("You must provide a session token using -token")
+	cfg, err := loadSellerConfig(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Flags given on the command line win over the config file and the environment
+	runnerFlags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "server":
+			cfg.Server.Address = *nakamaServer
+		case "token":
+			cfg.Identity.Token = *sessionToken
+		case "runtime":
+			cfg.Runtime = *runtimeName
+		case "inline-output":
+			cfg.Output.InlineBytes = *inlineOutput
+		case "max-output":
+			cfg.Output.MaxBytes = *maxOutput
+		case "image-policy":
+			cfg.ImagePolicyFile, cfg.ImagePolicy = *policyFile, nil
+		case "sandbox":
+			cfg.Sandbox = *sandboxName
+		case "slots":
+			cfg.Slots = *slots
+		case "queue":
+			cfg.Queue = *queueSize
+		case "max-artifacts":
+			cfg.Output.ArtifactBytes = *maxArtifacts
+		case "drain-seconds":
+			cfg.DrainSeconds = *drainSeconds
+		}
+	})
+	if errs := cfg.Validate(); len(errs) > 0 {
+		for _, err := range errs {
+			log.Print(err)
+		}
+		log.Fatal("Invalid seller configuration")
 	}
 
-	// Check Docker availability
-	if err := checkDocker(); err != nil {
-		log.Fatalf("Docker not available: %v", err)
+	// Validate has already checked each of these
+	token, _ := cfg.SessionToken()
+	tlsConfig, _ := cfg.TLSConfig()
+	policy, _ := cfg.LoadImagePolicy()
+	sandbox, _ := LoadSandboxProfile(cfg.Sandbox)
+	rt, _ := NewRuntime(cfg.Runtime)
+	queue := cfg.Queue
+	if queue == 0 {
+		queue = cfg.Slots
 	}
 
-	sellerID := "seller-id-placeholder" // Optionally decode this from token or keep static
+	// Check the container runtime is available
+	version, err := rt.Version(context.Background())
+	if err != nil {
+		log.Fatalf("Container runtime %s not available: %v", rt.Name(), err)
+	}
+	log.Printf("Using container runtime %s (version %s)", rt.Name(), version)
 
-	registerSeller(*nakamaServer, *sessionToken, sellerID)
+	sellerID, err := auth.UserIDFromToken(token)
+	if err != nil {
+		log.Fatalf("Invalid session token: %v", err)
+	}
 
-	// Example: simulate receiving a job from queue or polling
-	go func() {
-		time.Sleep(2 * time.Second)
-		job := modules.JobRequest{
-			Image:   "python:3.10",
-			Command: "python -c 'print(\"Hello from Seller\")'",
-			BuyerID: "buyer123",
-			JobID:   uuid.New().String(),
-		}
-		executeJob(*nakamaServer, *sessionToken, sellerID, job)
-	}()
+	r := NewRunner(cfg.Server.Address, token, sellerID, rt)
+	r.TLS = tlsConfig
+	r.Limits = cfg.JobLimits()
+	r.InlineOutput = cfg.Output.InlineBytes
+	r.MaxOutput = cfg.Output.MaxBytes
+	r.MaxArtifacts = cfg.Output.ArtifactBytes
+	r.Policy = policy
+	r.Sandbox = sandbox
+	r.Pricing = cfg.PriceCard()
+	r.startWorkers(cfg.Slots, queue)
+	ttl := r.register()
+	go r.heartbeatLoop(ttl)
 
-	// Wait for CTRL+C
-	sigCh := make(chan os.Signal, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Job offers arrive as notifications on the realtime socket
+	socket := realtime.NewSocket(cfg.Server.Address, token)
+	socket.TLS = tlsConfig
+	socket.OnNotification = r.handleNotification
+	// Refresh the registration right away after a reconnect, since offers may have been missed
+	socket.OnConnect = r.sendHeartbeat
+	go socket.Run(ctx)
+
+	// Wait for CTRL+C, then drain; a second signal exits right away
+	sigCh := make(chan os.Signal, 2)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	<-sigCh
-	log.Println("Seller shutting down.")
+	log.Println("Seller shutting down, draining running jobs (signal again to exit now).")
+	go func() {
+		<-sigCh
+		log.Fatal("Seller stopped without draining.")
+	}()
+	r.Drain(time.Duration(cfg.DrainSeconds) * time.Second)
+	log.Println("Seller shut down.")
 }
 
-func checkDocker() error {
-	cmd := exec.Command("docker", "--version")
-	return cmd.Run()
+// Runner receives job offers for a seller and executes them with a container runtime
+type Runner struct {
+	Server   string                 // Nakama server address
+	Token    string                 // Session token of the seller
+	SellerID string                 // Nakama user ID of the seller
+	Limits   modules.Resources      // Most resources offered to a single job
+	Runtime  Runtime                // Container runtime jobs run in
+	Policy   *ImagePolicy           // Images the seller agrees to run
+	Sandbox  modules.SandboxProfile // Hardening applied to every job container
+	Pricing  *modules.PriceCard     // What the seller charges, nil for free
+	TLS      *tls.Config            // Set to reach the server over https
+
+	InlineOutput int   // Bytes of each output stream sent inline with a result
+	MaxOutput    int64 // Bytes of each output stream kept at all
+	MaxArtifacts int64 // Bytes of files from /out returned per job
+
+	jobs         *jobTracker
+	pool         *workerPool
+	slotsChanged chan struct{} // Signals the heartbeat loop to advertise new free slots
+	draining     atomic.Bool   // Set once the seller is shutting down
+
+	clientOnce sync.Once
+	client     *rpcclient.Client
 }
 
-func registerSeller(server, token, sellerID string) {
-	payload := map[string]interface{}{
-		"user_id":      sellerID,
-		"capabilities": []string{"python:3.10", "node:16", "ubuntu:latest"},
+// NewRunner creates a runner offering this host's resources
+func NewRunner(server, token, sellerID string, rt Runtime) *Runner {
+	return &Runner{
+		Server:       server,
+		Token:        token,
+		SellerID:     sellerID,
+		Limits:       detectLimits(),
+		Runtime:      rt,
+		Policy:       &DefaultImagePolicy,
+		Sandbox:      SandboxProfiles["default"],
+		InlineOutput: DefaultInlineOutput,
+		MaxOutput:    DefaultMaxOutput,
+		MaxArtifacts: DefaultMaxArtifacts,
+		jobs:         &jobTracker{cancels: make(map[string]context.CancelFunc), interrupted: make(map[string]bool)},
+		slotsChanged: make(chan struct{}, 1),
 	}
+}
 
-	data, _ := json.Marshal(payload)
+// startWorkers starts running offered jobs on the given number of slots,
+// keeping up to queueSize more waiting for a free slot
+func (r *Runner) startWorkers(slots, queueSize int) {
+	r.pool = newWorkerPool(slots, queueSize, r.executeJob, func() {
+		select {
+		case r.slotsChanged <- struct{}{}:
+		default:
+		}
+	})
+	r.pool.start()
+}
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s/v2/rpc/register_seller", server), bytes.NewBuffer(data))
-	if err != nil {
-		log.Fatalf("Error building register request: %v", err)
+// rpc calls a Nakama RPC as the seller
+func (r *Runner) rpc(name string, payload interface{}) ([]byte, error) {
+	r.clientOnce.Do(func() {
+		r.client = rpcclient.New(r.Server, r.Token, r.TLS)
+	})
+	return r.client.Call(name, payload)
+}
+
+// limits is what the seller can give a job: its configured limits,
+// tightened by the ceilings of its sandbox profile
+func (r *Runner) limits() modules.Resources {
+	return sandboxResources(r.Sandbox, r.Limits)
+}
+
+// register registers the seller with the server and returns the TTL granted to it
+func (r *Runner) register() time.Duration {
+	payload := map[string]interface{}{
+		"user_id":        r.SellerID,
+		"capabilities":   r.Policy.Capabilities(),
+		"denied_images":  r.Policy.Deny,
+		"require_digest": r.Policy.RequireDigest,
+		"labels":         []string{"os=" + runtime.GOOS, "arch=" + runtime.GOARCH},
+		"limits":         r.limits(),
+		"slots":          r.pool.slots,
+		"free_slots":     r.pool.free(),
+		"pricing":        r.Pricing,
+		"ttl":            modules.DefaultSellerTTL,
 	}
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	body, err := r.rpc("register_seller", payload)
 	if err != nil {
 		log.Fatalf("Failed to register seller: %v", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		log.Fatalf("Registration failed [%d]: %s", resp.StatusCode, body)
+	var status struct {
+		TTL int64 `json:"ttl"`
+	}
+	if err := json.Unmarshal(body, &status); err != nil || status.TTL <= 0 {
+		status.TTL = modules.DefaultSellerTTL
 	}
 
 	log.Println("Seller registered successfully.")
+	return time.Duration(status.TTL) * time.Second
 }
 
-func executeJob(server, token, sellerID string, job modules.JobRequest) {
-	log.Printf("Executing job: %s using image: %s", job.JobID, job.Image)
+// heartbeatLoop keeps the seller registration alive by sending heartbeats well
+// within the TTL, and sends one right away whenever a slot is taken or freed
+func (r *Runner) heartbeatLoop(ttl time.Duration) {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
 
-	result := modules.JobResult{
-		JobID:     job.JobID,
-		BuyerID:   job.BuyerID,
-		SellerID:  sellerID,
-		Timestamp: time.Now().Unix(),
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.slotsChanged:
+		}
+		r.sendHeartbeat()
+	}
+}
+
+// sendHeartbeat tells the server the seller is still online, how many slots
+// are free and whether it is draining
+func (r *Runner) sendHeartbeat() {
+	payload := map[string]interface{}{
+		"free_slots": r.pool.free(),
+		"draining":   r.draining.Load(),
 	}
+	if _, err := r.rpc("seller_heartbeat", payload); err != nil {
+		log.Printf("Heartbeat failed: %v", err)
+	}
+}
+
+// Drain shuts the seller down gracefully. It stops taking offers, drops the
+// queued ones and tells the server it is draining, then gives running jobs
+// the grace period to finish. Jobs still running after that are killed and
+// reported as interrupted so the server requeues them. Finally the seller
+// is removed from the registry.
+func (r *Runner) Drain(grace time.Duration) {
+	r.draining.Store(true)
+	r.pool.stop()
+	r.sendHeartbeat()
 
-	cmd := exec.Command("docker", "run", "--rm", "--network=none", "--memory=512m", "--cpus=1", job.Image, "sh", "-c", job.Command)
-	output, err := cmd.CombinedOutput()
+	finished := make(chan struct{})
+	go func() {
+		r.pool.wait()
+		close(finished)
+	}()
+
+	if n := r.jobs.running(); n > 0 {
+		log.Printf("Waiting up to %s for %d running job(s) to finish", grace, n)
+	}
+	select {
+	case <-finished:
+	case <-time.After(grace):
+		log.Printf("Grace period over, interrupting %d job(s)", r.jobs.interruptAll())
+		select {
+		case <-finished:
+		case <-time.After(interruptTimeout):
+			log.Printf("Jobs did not report their interruption within %s", interruptTimeout)
+		}
+	}
+
+	r.deregister()
+}
+
+// deregister removes the seller from the server's registry
+func (r *Runner) deregister() {
+	if _, err := r.rpc("deregister_seller", map[string]interface{}{"user_id": r.SellerID}); err != nil {
+		log.Printf("Failed to deregister seller: %v", err)
+		return
+	}
+	log.Println("Seller deregistered.")
+}
+
+// handleNotification dispatches a notification received on the realtime socket
+func (r *Runner) handleNotification(n realtime.Notification) {
+	var content modules.NotificationContent
+	if err := json.Unmarshal([]byte(n.Content), &content); err != nil {
+		log.Printf("Ignoring malformed notification %s: %v", n.ID, err)
+		return
+	}
+
+	switch n.Code {
+	case modules.NotificationJobOffer:
+		var job modules.JobRequest
+		if err := json.Unmarshal(content.Data, &job); err != nil || job.JobID == "" {
+			log.Printf("Ignoring malformed job offer %s", n.ID)
+			return
+		}
+		log.Printf("Received job offer %s for image %s", job.JobID, job.Image)
+		if r.draining.Load() {
+			log.Printf("Refusing job %s: seller is draining", job.JobID)
+			return
+		}
+
+		// Refuse jobs that ask for more than this seller offers, or run an image
+		// its policy forbids, before claiming them so other sellers can take them
+		job.Resources = job.Resources.WithDefaults()
+		if over := job.Resources.Exceeds(r.limits()); len(over) > 0 {
+			log.Printf("Refusing job %s: exceeds seller limits (%s)", job.JobID, strings.Join(over, ", "))
+			return
+		}
+		if err := r.Policy.Check(job.Image); err != nil {
+			log.Printf("Refusing job %s: %v", job.JobID, err)
+			return
+		}
+		if !r.pool.submit(job) {
+			log.Printf("Refusing job %s: already queued or no room in the local queue", job.JobID)
+		}
+
+	case modules.NotificationJobAuction:
+		var announcement modules.AuctionAnnouncement
+		if err := json.Unmarshal(content.Data, &announcement); err != nil || announcement.Job.JobID == "" {
+			log.Printf("Ignoring malformed auction %s", n.ID)
+			return
+		}
+		log.Printf("Job %s is up for auction until %d", announcement.Job.JobID, announcement.ClosesAt)
+		go r.bidOnJob(announcement)
+
+	case modules.NotificationJobCancel:
+		var cancel struct {
+			JobID  string `json:"job_id"`
+			Reason string `json:"reason"`
+		}
+		if err := json.Unmarshal(content.Data, &cancel); err != nil {
+			log.Printf("Ignoring malformed cancellation %s", n.ID)
+			return
+		}
+		if r.jobs.cancel(cancel.JobID) {
+			log.Printf("Cancelling job %s: %s", cancel.JobID, cancel.Reason)
+		}
+	}
+}
 
-	result.Output = string(output)
+// claimJob asks the server for the exclusive lease on a job before running
+// it. Offers leave the inputs out; they come with the lease.
+func (r *Runner) claimJob(jobID string) (*modules.JobInputs, error) {
+	body, err := r.rpc("claim_job", map[string]interface{}{
+		"job_id":    jobID,
+		"seller_id": r.SellerID,
+	})
 	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			result.ExitCode = exitErr.ExitCode()
-		} else {
-			result.ExitCode = -1
+		return nil, err
+	}
+	var lease struct {
+		Job modules.JobRequest `json:"job"`
+	}
+	if err := json.Unmarshal(body, &lease); err != nil {
+		return nil, fmt.Errorf("invalid claim_job response: %w", err)
+	}
+	return lease.Job.Inputs, nil
+}
+
+// renewLeaseLoop renews the lease on a job until done is closed. The first
+// renewal tells the server the job is running. A failed renewal is retried
+// soon after; stop is called so the container is killed once the server
+// says the lease is lost, or once the lease has run out without a renewal.
+func (r *Runner) renewLeaseLoop(jobID string, done <-chan struct{}, stop func()) {
+	interval := modules.JobLeaseDuration * time.Second / 3
+	expires := time.Now().Add(modules.JobLeaseDuration * time.Second)
+	for {
+		_, err := r.rpc("renew_job_lease", map[string]interface{}{
+			"job_id":    jobID,
+			"seller_id": r.SellerID,
+		})
+		wait := interval
+		switch {
+		case err == nil:
+			expires = time.Now().Add(modules.JobLeaseDuration * time.Second)
+		case leaseLost(err):
+			log.Printf("Job %s is no longer ours, stopping it: %v", jobID, err)
+			stop()
+			return
+		case time.Now().After(expires):
+			log.Printf("Lease on job %s ran out without a renewal, stopping it: %v", jobID, err)
+			stop()
+			return
+		default:
+			log.Printf("Failed to renew lease on job %s, retrying: %v", jobID, err)
+			wait = renewRetryInterval
+		}
+
+		select {
+		case <-done:
+			return
+		case <-time.After(wait):
 		}
-		result.Error = err.Error()
-	} else {
-		result.ExitCode = 0
 	}
+}
 
-	resultJSON, _ := json.Marshal(result)
+// leaseLost reports whether the server refused a call because the seller no
+// longer holds the job's lease, as opposed to failing for some other reason
+func leaseLost(err error) bool {
+	var rejected *rpcclient.Error
+	return errors.As(err, &rejected) && strings.Contains(rejected.Body, modules.JobLeaseLostMessage)
+}
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s/v2/rpc/submit_job_result", server), bytes.NewBuffer(resultJSON))
+// executeJob claims a job, runs it and reports the result
+func (r *Runner) executeJob(job modules.JobRequest) {
+	inputs, err := r.claimJob(job.JobID)
 	if err != nil {
-		log.Printf("Failed to build result request: %v", err)
+		log.Printf("Skipping job %s: %v", job.JobID, err)
 		return
 	}
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "application/json")
+	job.Inputs = inputs
+
+	timeout := time.Duration(job.Resources.TimeoutSeconds) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Cancellation from the server cancels ctx, which stops the container
+	r.jobs.add(job.JobID, cancel)
+	defer r.jobs.remove(job.JobID)
+
+	// Keep the lease alive while the container runs so the job is not handed to
+	// another seller. Renewing stops before the result is submitted, so a
+	// renewal cannot race the submission.
+	done := make(chan struct{})
+	renewed := make(chan struct{})
+	go func() {
+		r.renewLeaseLoop(job.JobID, done, cancel)
+		close(renewed)
+	}()
+	var stopOnce sync.Once
+	stopRenewing := func() {
+		stopOnce.Do(func() {
+			close(done)
+			<-renewed
+		})
+	}
+	defer stopRenewing()
+	submit := func(result modules.JobResult) {
+		stopRenewing()
+		r.submitResult(result)
+	}
+
+	log.Printf("Executing job: %s using image: %s", job.JobID, job.Image)
+
+	result := modules.JobResult{
+		JobID:     job.JobID,
+		BuyerID:   job.BuyerID,
+		SellerID:  r.SellerID,
+		Timestamp: time.Now().Unix(),
+		Sandbox:   &r.Sandbox,
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	files, err := prepareJobFiles(job)
 	if err != nil {
-		log.Printf("Failed to submit job result: %v", err)
+		result.ExitCode = -1
+		result.Error = fmt.Sprintf("invalid inputs: %v", err)
+		submit(result)
 		return
 	}
-	defer resp.Body.Close()
+	defer files.remove()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		log.Printf("Job result rejected [%d]: %s", resp.StatusCode, body)
+	// Output is forwarded to the server line by line and also kept for the final result.
+	// Each stream is written by a single goroutine, so the buffers need no locking.
+	outputs := map[string]*outputBuffer{
+		"stdout": newOutputBuffer(r.InlineOutput, r.MaxOutput),
+		"stderr": newOutputBuffer(r.InlineOutput, r.MaxOutput),
+	}
+	for _, b := range outputs {
+		defer b.close()
+	}
+	shipper := newLogShipper(r, job.JobID)
+	shipDone := make(chan struct{})
+	shipped := make(chan struct{})
+	go func() {
+		shipper.run(shipDone)
+		close(shipped)
+	}()
+
+	usage := &modules.Usage{}
+	result.Usage = usage
+	exitCode, err := r.runContainer(ctx, job, files.mounts(), usage, func(stream, line string) {
+		io.WriteString(outputs[stream], line)
+		shipper.add(stream, line)
+	})
+	close(shipDone)
+	<-shipped
+
+	if r.jobs.wasInterrupted(job.JobID) {
+		// Report the job unfinished so the server hands it to another seller
+		log.Printf("Job %s was interrupted", job.JobID)
+		result.Interrupted = true
+		result.ExitCode = -1
+		result.Error = "interrupted: seller shut down before the job finished"
+		submit(result)
+		return
+	}
+	if ctx.Err() == context.Canceled {
+		// The server already holds the final (cancelled) result, so there is nothing to report
+		log.Printf("Job %s was cancelled", job.JobID)
 		return
 	}
 
-	log.Printf("Job %s submitted successfully", job.JobID)
+	result.Stdout = outputs["stdout"].inline.String()
+	result.Stderr = outputs["stderr"].inline.String()
+	for stream, b := range outputs {
+		overflow, err := r.uploadOutput(job.JobID, stream, b)
+		if err != nil {
+			// Still report the result, with the inline part only
+			log.Printf("Failed to store %s of job %s: %v", stream, job.JobID, err)
+			result.OutputTruncated = true
+			continue
+		}
+		if overflow != nil {
+			if result.Overflow == nil {
+				result.Overflow = make(map[string]modules.OutputOverflow)
+			}
+			result.Overflow[stream] = *overflow
+		}
+		if b.truncated {
+			result.OutputTruncated = true
+		}
+	}
+
+	for _, b := range outputs {
+		usage.OutputBytes += b.total
+	}
+
+	artifacts, overflow, artifactErr := r.collectArtifacts(job.JobID, files)
+	if artifactErr != nil {
+		log.Printf("Failed to collect artifacts of job %s: %v", job.JobID, artifactErr)
+	} else if artifacts != nil {
+		usage.ArtifactBytes = artifacts.Bytes
+		if result.Overflow == nil {
+			result.Overflow = make(map[string]modules.OutputOverflow)
+		}
+		result.Overflow["artifacts"] = *overflow
+		result.Artifacts = artifacts
+	}
+
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		result.ExitCode = -1
+		result.Error = fmt.Sprintf("job exceeded its %s timeout", timeout)
+	case err != nil:
+		result.ExitCode = -1
+		result.Error = err.Error()
+	default:
+		result.ExitCode = exitCode
+		if exitCode != 0 {
+			result.Error = fmt.Sprintf("exit status %d", exitCode)
+		} else if artifactErr != nil {
+			result.Error = fmt.Sprintf("failed to collect artifacts: %v", artifactErr)
+		}
+	}
+
+	submit(result)
+}
+
+// submitResult reports the outcome of a job to the server. Sends that fail
+// on the way to the server or lose a race with another update of the job
+// are retried with backoff.
+func (r *Runner) submitResult(result modules.JobResult) {
+	backoff := submitBackoff
+	for attempt := 1; ; attempt++ {
+		_, err := r.rpc("submit_job_result", result)
+		if err == nil {
+			break
+		}
+		if attempt == submitAttempts || !retryable(err) {
+			log.Printf("Failed to submit result of job %s: %v", result.JobID, err)
+			return
+		}
+		log.Printf("Failed to submit result of job %s, retrying in %s: %v", result.JobID, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	log.Printf("Job %s submitted successfully", result.JobID)
+}
+
+// retryable reports whether a failed RPC may succeed when sent again: the
+// request never reached the server, the server was unavailable, or the call
+// lost a race with another update of the job
+func retryable(err error) bool {
+	var rejected *rpcclient.Error
+	if !errors.As(err, &rejected) {
+		return true
+	}
+	switch rejected.Status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return strings.Contains(rejected.Body, modules.JobConflictMessage)
+}
+
+// jobTracker maps running job IDs to the function that stops them
+type jobTracker struct {
+	mu           sync.Mutex
+	cancels      map[string]context.CancelFunc
+	interrupted  map[string]bool // Jobs stopped because the seller is shutting down
+	interrupting bool            // Set once every job, including ones starting now, is to be stopped
+}
+
+func (t *jobTracker) add(jobID string, cancel context.CancelFunc) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cancels[jobID] = cancel
+	if t.interrupting {
+		t.interrupted[jobID] = true
+		cancel()
+	}
+}
+
+func (t *jobTracker) remove(jobID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.cancels, jobID)
+	delete(t.interrupted, jobID)
+}
+
+// running returns how many jobs are running
+func (t *jobTracker) running() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.cancels)
+}
+
+// interruptAll stops every running job and any job that starts afterwards,
+// returning how many were running
+func (t *jobTracker) interruptAll() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.interrupting = true
+	for jobID, cancel := range t.cancels {
+		t.interrupted[jobID] = true
+		cancel()
+	}
+	return len(t.cancels)
+}
+
+// wasInterrupted reports whether interruptAll stopped the job
+func (t *jobTracker) wasInterrupted(jobID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.interrupted[jobID]
+}
+
+// cancel stops a running job, reporting whether it was running here
+func (t *jobTracker) cancel(jobID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cancel, ok := t.cancels[jobID]
+	if ok {
+		cancel()
+	}
+	return ok
 }
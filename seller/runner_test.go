@@ -0,0 +1,479 @@
+package seller
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bdr-pro/lumaris/bundle"
+	"github.com/bdr-pro/lumaris/modules"
+	"github.com/bdr-pro/lumaris/realtime"
+)
+
+// rpcCall is an RPC received by the fake server
+type rpcCall struct {
+	name    string
+	payload json.RawMessage
+}
+
+// fakeServer stands in for the Nakama RPC API. Every RPC succeeds with an
+// empty object, or the response set for it, unless it is listed in reject.
+type fakeServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	calls     []rpcCall
+	reject    map[string]string // Error message of each rejected RPC
+	responses map[string]string
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	s := &fakeServer{reject: make(map[string]string), responses: make(map[string]string)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		name := strings.TrimPrefix(req.URL.Path, "/v2/rpc/")
+		var payload json.RawMessage
+		json.NewDecoder(req.Body).Decode(&payload)
+
+		s.mu.Lock()
+		s.calls = append(s.calls, rpcCall{name: name, payload: payload})
+		message, rejected := s.reject[name]
+		response, ok := s.responses[name]
+		s.mu.Unlock()
+
+		if rejected {
+			http.Error(w, message, http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			response = "{}"
+		}
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// rejectRPC makes every later call of the RPC fail
+func (s *fakeServer) rejectRPC(name string) {
+	s.rejectRPCWith(name, name+" rejected")
+}
+
+// rejectRPCWith makes every later call of the RPC fail with the given message
+func (s *fakeServer) rejectRPCWith(name, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reject[name] = message
+}
+
+// respond makes every later call of the RPC return the value encoded as JSON
+func (s *fakeServer) respond(t *testing.T, name string, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses[name] = string(data)
+}
+
+// acceptRPC makes later calls of a rejected RPC succeed again
+func (s *fakeServer) acceptRPC(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.reject, name)
+}
+
+// payloads returns the payload of every call of the RPC so far, in order
+func (s *fakeServer) payloads(name string) []json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var payloads []json.RawMessage
+	for _, c := range s.calls {
+		if c.name == name {
+			payloads = append(payloads, c.payload)
+		}
+	}
+	return payloads
+}
+
+// names returns the RPCs called so far, in order
+func (s *fakeServer) names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.calls))
+	for _, c := range s.calls {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+// results returns every result submitted so far
+func (s *fakeServer) results(t *testing.T) []modules.JobResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var results []modules.JobResult
+	for _, c := range s.calls {
+		if c.name != "submit_job_result" {
+			continue
+		}
+		var result modules.JobResult
+		if err := json.Unmarshal(c.payload, &result); err != nil {
+			t.Fatalf("invalid submit_job_result payload: %v", err)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// newTestRunner returns a runner talking to the fake server and running jobs on rt
+func newTestRunner(server *fakeServer, rt *FakeRuntime) *Runner {
+	return NewRunner(strings.TrimPrefix(server.URL, "http://"), "token", "seller-1", rt)
+}
+
+// testJob returns a job request the default runner accepts
+func testJob(jobID string) modules.JobRequest {
+	return modules.JobRequest{
+		JobID:     jobID,
+		BuyerID:   "buyer-1",
+		Image:     "python:3.10",
+		Command:   "python -c 'print(42)'",
+		Resources: modules.Resources{TimeoutSeconds: 30}.WithDefaults(),
+	}
+}
+
+// waitFor fails the test unless cond holds within a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func indexOf(list []string, value string) int {
+	for i, v := range list {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestExecuteJobClaimsRunsAndSubmits(t *testing.T) {
+	server := newFakeServer(t)
+	rt := NewFakeRuntime()
+	rt.Behavior = func(spec ContainerSpec) FakeOutcome {
+		return FakeOutcome{Stdout: "42\n", Stderr: "warning\n"}
+	}
+	r := newTestRunner(server, rt)
+
+	r.executeJob(testJob("job-1"))
+
+	names := server.names()
+	claim, submit := indexOf(names, "claim_job"), indexOf(names, "submit_job_result")
+	if claim != 0 || submit < 0 {
+		t.Fatalf("want claim_job first and submit_job_result later, got %v", names)
+	}
+	if renew := indexOf(names, "renew_job_lease"); renew < 0 || renew > submit {
+		t.Errorf("want the lease renewed before the result is submitted, got %v", names)
+	}
+
+	specs := rt.Specs()
+	if len(specs) != 1 {
+		t.Fatalf("want 1 container, got %d", len(specs))
+	}
+	if specs[0].Name != "lumaris-job-1" || !specs[0].NetworkDisabled {
+		t.Errorf("unexpected container spec %+v", specs[0])
+	}
+
+	results := server.results(t)
+	if len(results) != 1 {
+		t.Fatalf("want 1 result, got %d", len(results))
+	}
+	result := results[0]
+	if result.ExitCode != 0 || result.Error != "" {
+		t.Errorf("want a successful result, got exit code %d and error %q", result.ExitCode, result.Error)
+	}
+	if result.Stdout != "42\n" || result.Stderr != "warning\n" {
+		t.Errorf("unexpected output %q / %q", result.Stdout, result.Stderr)
+	}
+	if result.Usage == nil || result.Usage.StartedAt == 0 {
+		t.Errorf("want usage with a start time, got %+v", result.Usage)
+	}
+}
+
+func TestExecuteJobTakesInputsFromLease(t *testing.T) {
+	server := newFakeServer(t)
+	lease := testJob("job-1")
+	lease.Inputs = &modules.JobInputs{Files: []modules.InputFile{{
+		Path:   "data.txt",
+		Data:   base64.StdEncoding.EncodeToString([]byte("hello")),
+		SHA256: bundle.Checksum([]byte("hello")),
+	}}}
+	server.respond(t, "claim_job", map[string]interface{}{"job_id": "job-1", "job": lease})
+
+	rt := NewFakeRuntime()
+	var input string
+	rt.Behavior = func(spec ContainerSpec) FakeOutcome {
+		for _, m := range spec.Mounts {
+			if m.Target == "/in" {
+				data, _ := os.ReadFile(filepath.Join(m.Source, "data.txt"))
+				input = string(data)
+			}
+		}
+		return FakeOutcome{}
+	}
+	r := newTestRunner(server, rt)
+
+	// Offers carry no inputs
+	r.executeJob(testJob("job-1"))
+
+	if input != "hello" {
+		t.Errorf("want the leased input mounted at /in, got %q", input)
+	}
+}
+
+func TestExecuteJobReportsExitCode(t *testing.T) {
+	server := newFakeServer(t)
+	rt := NewFakeRuntime()
+	rt.Behavior = func(spec ContainerSpec) FakeOutcome { return FakeOutcome{ExitCode: 3} }
+	r := newTestRunner(server, rt)
+
+	r.executeJob(testJob("job-1"))
+
+	results := server.results(t)
+	if len(results) != 1 || results[0].ExitCode != 3 || results[0].Error == "" {
+		t.Fatalf("want one failed result with exit code 3, got %+v", results)
+	}
+}
+
+func TestExecuteJobSkipsJobItCannotClaim(t *testing.T) {
+	server := newFakeServer(t)
+	server.rejectRPC("claim_job")
+	rt := NewFakeRuntime()
+	r := newTestRunner(server, rt)
+
+	r.executeJob(testJob("job-1"))
+
+	if specs := rt.Specs(); len(specs) != 0 {
+		t.Errorf("want no container for an unclaimed job, got %d", len(specs))
+	}
+	if names := server.names(); len(names) != 1 {
+		t.Errorf("want only the claim, got %v", names)
+	}
+}
+
+func TestOfferRefusedByPolicyIsNotClaimed(t *testing.T) {
+	server := newFakeServer(t)
+	r := newTestRunner(server, NewFakeRuntime())
+	r.Policy = &ImagePolicy{Allow: []string{"python:*", "node:*"}, Deny: []string{"python:3.10"}}
+	r.startWorkers(1, 1)
+
+	offer := func(job modules.JobRequest) {
+		content, _ := json.Marshal(map[string]interface{}{"type": "job_request", "data": job})
+		r.handleNotification(realtime.Notification{ID: job.JobID, Code: modules.NotificationJobOffer, Content: string(content)})
+	}
+	allowed := testJob("job-2")
+	allowed.Image = "node:16"
+	offer(testJob("job-1"))
+	offer(allowed)
+	waitFor(t, "the allowed job to finish", func() bool {
+		results := server.results(t)
+		return len(results) > 0 && results[len(results)-1].JobID == "job-2"
+	})
+
+	for _, p := range server.payloads("claim_job") {
+		if strings.Contains(string(p), "job-1") {
+			t.Errorf("want the refused job left unclaimed, got claim %s", p)
+		}
+	}
+}
+
+func TestRegisterAdvertisesImagePolicy(t *testing.T) {
+	server := newFakeServer(t)
+	r := newTestRunner(server, NewFakeRuntime())
+	r.Policy = &ImagePolicy{Allow: []string{"python:*"}, Deny: []string{"python:3.6*"}, RequireDigest: true}
+	r.startWorkers(1, 1)
+	r.register()
+
+	payloads := server.payloads("register_seller")
+	if len(payloads) != 1 {
+		t.Fatalf("want one registration, got %d", len(payloads))
+	}
+	var registration struct {
+		Capabilities  []string `json:"capabilities"`
+		DeniedImages  []string `json:"denied_images"`
+		RequireDigest bool     `json:"require_digest"`
+	}
+	if err := json.Unmarshal(payloads[0], &registration); err != nil {
+		t.Fatal(err)
+	}
+	if len(registration.Capabilities) != 1 || len(registration.DeniedImages) != 1 || !registration.RequireDigest {
+		t.Errorf("want the whole image policy advertised, got %+v", registration)
+	}
+}
+
+func TestCancelNotificationStopsJob(t *testing.T) {
+	server := newFakeServer(t)
+	rt := NewFakeRuntime()
+	rt.Behavior = func(spec ContainerSpec) FakeOutcome { return FakeOutcome{Duration: time.Minute} }
+	r := newTestRunner(server, rt)
+
+	finished := make(chan struct{})
+	go func() {
+		r.executeJob(testJob("job-1"))
+		close(finished)
+	}()
+	waitFor(t, "the job to start", func() bool { return r.jobs.running() == 1 && len(rt.Specs()) == 1 })
+
+	content, _ := json.Marshal(map[string]interface{}{
+		"type": "job_cancel",
+		"data": map[string]string{"job_id": "job-1", "reason": "cancelled by buyer"},
+	})
+	r.handleNotification(realtime.Notification{ID: "n1", Code: modules.NotificationJobCancel, Content: string(content)})
+
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelled job did not stop")
+	}
+	if !rt.Killed("lumaris-job-1") {
+		t.Error("want the container killed")
+	}
+	// The server already holds the cancelled result
+	if results := server.results(t); len(results) != 0 {
+		t.Errorf("want no result for a cancelled job, got %+v", results)
+	}
+}
+
+func TestFailedRenewalKeepsJobRunning(t *testing.T) {
+	server := newFakeServer(t)
+	server.rejectRPCWith("renew_job_lease", "internal server error")
+	rt := NewFakeRuntime()
+	rt.Behavior = func(spec ContainerSpec) FakeOutcome {
+		return FakeOutcome{Stdout: "done\n", Duration: 200 * time.Millisecond}
+	}
+	r := newTestRunner(server, rt)
+
+	r.executeJob(testJob("job-1"))
+
+	if rt.Killed("lumaris-job-1") {
+		t.Error("want the container left running through a server error")
+	}
+	results := server.results(t)
+	if len(results) != 1 || results[0].ExitCode != 0 || results[0].Stdout != "done\n" {
+		t.Fatalf("want the job to finish normally, got %+v", results)
+	}
+}
+
+func TestLostLeaseStopsJob(t *testing.T) {
+	server := newFakeServer(t)
+	server.rejectRPCWith("renew_job_lease", modules.JobLeaseLostMessage)
+	rt := NewFakeRuntime()
+	rt.Behavior = func(spec ContainerSpec) FakeOutcome { return FakeOutcome{Duration: time.Minute} }
+	r := newTestRunner(server, rt)
+
+	finished := make(chan struct{})
+	go func() {
+		r.executeJob(testJob("job-1"))
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job kept running after its lease was lost")
+	}
+	if !rt.Killed("lumaris-job-1") {
+		t.Error("want the container killed")
+	}
+}
+
+func TestDrainLetsRunningJobsFinish(t *testing.T) {
+	server := newFakeServer(t)
+	rt := NewFakeRuntime()
+	rt.Behavior = func(spec ContainerSpec) FakeOutcome {
+		return FakeOutcome{Stdout: "done\n", Duration: 100 * time.Millisecond}
+	}
+	r := newTestRunner(server, rt)
+	r.startWorkers(1, 1)
+
+	if !r.pool.submit(testJob("job-1")) {
+		t.Fatal("job was not admitted")
+	}
+	waitFor(t, "the job to start", func() bool { return len(rt.Specs()) == 1 })
+	r.Drain(5 * time.Second)
+
+	results := server.results(t)
+	if len(results) != 1 || results[0].Interrupted || results[0].Stdout != "done\n" {
+		t.Fatalf("want the job to finish normally, got %+v", results)
+	}
+	names := server.names()
+	if indexOf(names, "deregister_seller") != len(names)-1 {
+		t.Errorf("want the seller deregistered last, got %v", names)
+	}
+}
+
+func TestDrainInterruptsJobsPastTheGracePeriod(t *testing.T) {
+	server := newFakeServer(t)
+	rt := NewFakeRuntime()
+	rt.Behavior = func(spec ContainerSpec) FakeOutcome { return FakeOutcome{Duration: time.Minute} }
+	r := newTestRunner(server, rt)
+	r.startWorkers(1, 1)
+
+	if !r.pool.submit(testJob("job-1")) {
+		t.Fatal("job was not admitted")
+	}
+	waitFor(t, "the job to start", func() bool { return r.jobs.running() == 1 && len(rt.Specs()) == 1 })
+	r.Drain(50 * time.Millisecond)
+
+	if !rt.Killed("lumaris-job-1") {
+		t.Error("want the container killed")
+	}
+	results := server.results(t)
+	if len(results) != 1 || !results[0].Interrupted {
+		t.Fatalf("want one interrupted result, got %+v", results)
+	}
+	if r.pool.submit(testJob("job-2")) {
+		t.Error("a draining seller must not admit new jobs")
+	}
+	if indexOf(server.names(), "deregister_seller") < 0 {
+		t.Error("want the seller deregistered")
+	}
+}
+
+func TestOfferAboveSandboxCeilingIsNotClaimed(t *testing.T) {
+	server := newFakeServer(t)
+	r := newTestRunner(server, NewFakeRuntime())
+	r.Sandbox = modules.SandboxProfile{Name: "hardened", PidsLimit: 256}
+	r.startWorkers(1, 1)
+
+	if got := r.limits().PidsLimit; got != 256 {
+		t.Errorf("want the sandbox's pids ceiling advertised, got %d", got)
+	}
+
+	job := testJob("job-1")
+	job.Resources.PidsLimit = 1024
+	content, _ := json.Marshal(map[string]interface{}{"type": "job_request", "data": job})
+	r.handleNotification(realtime.Notification{ID: "n1", Code: modules.NotificationJobOffer, Content: string(content)})
+	if !r.pool.submit(testJob("job-2")) {
+		t.Fatal("job was not admitted")
+	}
+	waitFor(t, "the next job to finish", func() bool { return len(server.results(t)) == 1 })
+
+	for _, p := range server.payloads("claim_job") {
+		if strings.Contains(string(p), "job-1") {
+			t.Errorf("want the job above the ceiling left unclaimed, got claim %s", p)
+		}
+	}
+}
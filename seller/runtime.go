@@ -0,0 +1,69 @@
+package seller
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/bdr-pro/lumaris/modules"
+)
+
+// Runtime is a container engine the seller runs jobs with
+type Runtime interface {
+	// Name identifies the runtime in logs
+	Name() string
+	// Version checks the runtime is reachable and returns its version
+	Version(ctx context.Context) (string, error)
+	// Pull makes sure the image is available locally
+	Pull(ctx context.Context, image string) error
+	// Create creates a container without starting it and returns its ID
+	Create(ctx context.Context, spec ContainerSpec) (string, error)
+	// Start starts a created container
+	Start(ctx context.Context, id string) error
+	// Wait blocks until the container exits and returns its exit code
+	Wait(ctx context.Context, id string) (int, error)
+	// Logs follows the container's stdout and stderr until it exits
+	Logs(ctx context.Context, id string) (stdout, stderr io.ReadCloser, err error)
+	// Kill stops a running container immediately
+	Kill(ctx context.Context, id string) error
+	// Remove deletes the container, killing it first if needed
+	Remove(ctx context.Context, id string) error
+	// Stats samples the container's current resource usage
+	Stats(ctx context.Context, id string) (ContainerStats, error)
+}
+
+// ContainerSpec describes the container a job runs in
+type ContainerSpec struct {
+	Name            string                 // Container name, derived from the job ID
+	Image           string                 // Image to run
+	Cmd             []string               // Command and arguments
+	Resources       modules.Resources      // CPU, memory, pids and scratch limits
+	NetworkDisabled bool                   // Run without any network access
+	Mounts          []Mount                // Host directories bound into the container
+	Sandbox         modules.SandboxProfile // Hardening applied to the container
+}
+
+// Mount binds a host directory into a container
+type Mount struct {
+	Source   string // Directory on the host
+	Target   string // Path inside the container
+	ReadOnly bool   // Prevent the container from writing to it
+}
+
+// ContainerStats is a point-in-time sample of a container's resource usage
+type ContainerStats struct {
+	CPUNanos    uint64  // Cumulative CPU time, zero if the runtime cannot report it
+	CPUPercent  float64 // Current CPU usage as a percentage of one core
+	MemoryBytes uint64  // Current memory usage
+}
+
+// NewRuntime returns the runtime with the given name
+func NewRuntime(name string) (Runtime, error) {
+	switch name {
+	case "docker-cli", "":
+		return NewDockerCLI(), nil
+	case "docker-engine":
+		return NewDockerEngine(defaultDockerSocket), nil
+	}
+	return nil, fmt.Errorf("unknown container runtime %q (want docker-cli or docker-engine)", name)
+}
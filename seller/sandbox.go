@@ -0,0 +1,113 @@
+package seller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/bdr-pro/lumaris/modules"
+)
+
+// SandboxProfiles are the built-in hardening profiles, selected by name
+var SandboxProfiles = map[string]modules.SandboxProfile{
+	// default only isolates the network and applies the job's resource limits
+	"default": {Name: "default"},
+	// hardened is meant for running untrusted code
+	"hardened": {
+		Name:            "hardened",
+		ReadOnlyRootfs:  true,
+		TmpMB:           64,
+		CapDrop:         []string{"ALL"},
+		NoNewPrivileges: true,
+		User:            "65534:65534",
+		PidsLimit:       256,
+		Ulimits: []modules.Ulimit{
+			{Name: "nofile", Soft: 1024, Hard: 1024},
+			{Name: "core", Soft: 0, Hard: 0},
+		},
+	},
+}
+
+// LoadSandboxProfile returns the built-in profile with the given name, or
+// reads a JSON profile from the file at that path
+func LoadSandboxProfile(nameOrPath string) (modules.SandboxProfile, error) {
+	if nameOrPath == "" {
+		nameOrPath = "default"
+	}
+	if profile, ok := SandboxProfiles[nameOrPath]; ok {
+		return profile, nil
+	}
+
+	data, err := os.ReadFile(nameOrPath)
+	if err != nil {
+		return modules.SandboxProfile{}, fmt.Errorf("unknown sandbox profile %q: %w", nameOrPath, err)
+	}
+	var profile modules.SandboxProfile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return modules.SandboxProfile{}, fmt.Errorf("invalid sandbox profile %s: %w", nameOrPath, err)
+	}
+	if err := validateSandbox(profile); err != nil {
+		return modules.SandboxProfile{}, fmt.Errorf("invalid sandbox profile %s: %w", nameOrPath, err)
+	}
+	return profile, nil
+}
+
+// validateSandbox checks a profile before any job runs with it
+func validateSandbox(p modules.SandboxProfile) error {
+	if p.Name == "" {
+		return errors.New("sandbox profile needs a name")
+	}
+	if p.TmpMB < 0 || p.PidsLimit < 0 {
+		return errors.New("tmp_mb and pids_limit must not be negative")
+	}
+	for _, u := range p.Ulimits {
+		if u.Name == "" || u.Soft > u.Hard {
+			return fmt.Errorf("invalid ulimit %q", u.Name)
+		}
+	}
+	if p.SeccompProfile != "" {
+		if _, err := os.Stat(p.SeccompProfile); err != nil {
+			return fmt.Errorf("seccomp profile: %w", err)
+		}
+	}
+	return nil
+}
+
+// sandboxFlags turns a sandbox profile into docker create flags
+func sandboxFlags(p modules.SandboxProfile) []string {
+	var flags []string
+	if p.ReadOnlyRootfs {
+		flags = append(flags, "--read-only")
+	}
+	if p.TmpMB > 0 {
+		flags = append(flags, fmt.Sprintf("--tmpfs=/tmp:rw,nosuid,nodev,size=%dm", p.TmpMB))
+	}
+	for _, c := range p.CapDrop {
+		flags = append(flags, "--cap-drop="+c)
+	}
+	for _, c := range p.CapAdd {
+		flags = append(flags, "--cap-add="+c)
+	}
+	if p.NoNewPrivileges {
+		flags = append(flags, "--security-opt=no-new-privileges")
+	}
+	if p.User != "" {
+		flags = append(flags, "--user="+p.User)
+	}
+	for _, u := range p.Ulimits {
+		flags = append(flags, fmt.Sprintf("--ulimit=%s=%d:%d", u.Name, u.Soft, u.Hard))
+	}
+	if p.SeccompProfile != "" {
+		flags = append(flags, "--security-opt=seccomp="+p.SeccompProfile)
+	}
+	return flags
+}
+
+// sandboxResources applies the profile's ceilings to a job's resources
+func sandboxResources(p modules.SandboxProfile, r modules.Resources) modules.Resources {
+	if p.PidsLimit > 0 && (r.PidsLimit == 0 || r.PidsLimit > p.PidsLimit) {
+		r.PidsLimit = p.PidsLimit
+	}
+	return r
+}
@@ -0,0 +1,73 @@
+package seller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bdr-pro/lumaris/modules"
+)
+
+// statsInterval is how often a running container's resource usage is sampled
+const statsInterval = time.Second
+
+// usageMeter accumulates stats samples of a running container
+type usageMeter struct {
+	mu         sync.Mutex
+	cpuNanos   uint64  // Latest cumulative CPU time, when the runtime reports it
+	cpuSeconds float64 // CPU time estimated from percentages, for runtimes that do not
+	peakMemory uint64
+	samples    int
+}
+
+// sample polls the container's stats until ctx ends
+func (m *usageMeter) sample(ctx context.Context, rt Runtime, id string) {
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		stats, err := rt.Stats(ctx, id)
+		if err != nil {
+			// The container may have just exited; later samples or the final figures cover it
+			continue
+		}
+		now := time.Now()
+		m.add(stats, now.Sub(last))
+		last = now
+	}
+}
+
+// add records one sample covering the given time since the previous one
+func (m *usageMeter) add(stats ContainerStats, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.samples++
+	if stats.CPUNanos > m.cpuNanos {
+		m.cpuNanos = stats.CPUNanos
+	}
+	m.cpuSeconds += stats.CPUPercent / 100 * elapsed.Seconds()
+	if stats.MemoryBytes > m.peakMemory {
+		m.peakMemory = stats.MemoryBytes
+	}
+}
+
+// fill copies the measured CPU and memory figures into a usage record
+func (m *usageMeter) fill(u *modules.Usage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u.CPUSeconds = m.cpuSeconds
+	if m.cpuNanos > 0 {
+		u.CPUSeconds = float64(m.cpuNanos) / 1e9
+	}
+	u.PeakMemoryBytes = m.peakMemory
+	u.Samples = m.samples
+}